@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestByBreach(t *testing.T) {
+	dir := t.TempDir()
+	mustRun(t, dir, "gen", "-c", "-n", "20", "-b", "3", "-seed", "1", "in.psv")
+	mustRun(t, dir, "-c", "-by-breach", "-o", "out", "-m", "maps/map.psv", "in.psv")
+
+	// each breach gets its own element files, manifest and map, and the
+	// statistics add up to the input
+	stats := readRows(t, dir, "out/"+BreachStatsFile)
+	if want := []string{"breach_id", "records", "name_dob", "ssn", "address", "phone", "email", "name_address", "name_phone", "username"}; !reflect.DeepEqual(stats[0], want) {
+		t.Errorf("statistics header = %q, want %q", stats[0], want)
+	}
+	if len(stats) != 4 {
+		t.Fatalf("statistics list %d breaches, want 3", len(stats)-1)
+	}
+	total := 0
+	for _, row := range stats[1:] {
+		breach := row[0]
+		records, _ := strconv.Atoi(row[1])
+		total += records
+		if rows := readRows(t, dir, filepath.Join("maps", breach, "map.psv")); len(rows)-1 != records {
+			t.Errorf("%s: map has %d rows, want %d", breach, len(rows)-1, records)
+		}
+		manifest := readRows(t, dir, filepath.Join("out", breach, ManifestFile))
+		if want := []string{"in.psv", "record", row[1]}; !reflect.DeepEqual(manifest[1], want) {
+			t.Errorf("%s: manifest counts %q, want %q", breach, manifest[1], want)
+		}
+		for i, entry := range manifest[2:] {
+			if entry[2] != row[2+i] {
+				t.Errorf("%s: statistics give %s %s elements, manifest %s", breach, row[2+i], entry[1], entry[2])
+			}
+			if rows := readRows(t, dir, filepath.Join("out", breach, entry[0])); strconv.Itoa(len(rows)-1) != entry[2] {
+				t.Errorf("%s: %s has %d rows, manifest %s", breach, entry[0], len(rows)-1, entry[2])
+			}
+		}
+	}
+	if total != 20 {
+		t.Errorf("statistics count %d records, want 20", total)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", ManifestFile)); err == nil {
+		t.Error("manifest written to the output directory itself")
+	}
+
+	// a breach id that cannot name a directory is refused
+	data, err := os.ReadFile(filepath.Join(dir, "in.psv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "bad.psv", strings.Replace(string(data), "BREACH-002", "../BREACH-002", 1))
+	stderr, err := run(t, dir, "-c", "-by-breach", "-o", "bad", "-m", "bad-maps/map.psv", "bad.psv")
+	if err == nil || !strings.Contains(stderr, "cannot name a directory") {
+		t.Errorf("bad breach id: got %v, %s", err, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad")); err == nil {
+		t.Error("output written for a bad breach id")
+	}
+}
+
+func TestBreaches(t *testing.T) {
+	dir := t.TempDir()
+	mustRun(t, dir, "gen", "-c", "-n", "10", "-b", "2", "-seed", "1", "-d", ";", "in.psv")
+
+	// the metadata is read like the input, here delimited by semicolons and
+	// encoded in Windows-1252
+	writeFile(t, dir, "meta.psv", "breach_id;name;date_discovered\n"+
+		"BREACH-002;Caf\xe9;13/01/2023\n"+
+		"BREACH-001;\"Forum; 2023\";2023-02-01\n"+
+		"BREACH-009;Unused;\n")
+	mustRun(t, dir, "-c", "-d", ";", "-breaches", "meta.psv", "-o", "out", "-m", "map.psv", "in.psv")
+	want := [][]string{
+		{"breach_id", "name", "date_discovered", "date_of_breach", "source", "data_classes"},
+		{"BREACH-001", "Forum; 2023", "2023-02-01", "", "", ""},
+		{"BREACH-002", "Café", "2023-01-13", "", "", ""},
+	}
+	if got := readRows(t, dir, "out/"+BreachesFile); !reflect.DeepEqual(got, want) {
+		t.Errorf("breach metadata = %q, want %q", got, want)
+	}
+
+	// a breach id missing from the metadata is refused
+	writeFile(t, dir, "meta.psv", "breach_id;name\nBREACH-001;Forum\n")
+	stderr, err := run(t, dir, "-c", "-d", ";", "-breaches", "meta.psv", "-o", "out2", "-m", "map2.psv", "in.psv")
+	if err == nil || !strings.Contains(stderr, "unknown breach id BREACH-002") {
+		t.Errorf("unknown breach id: got %v, %s", err, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out2")); err == nil {
+		t.Error("output written for an unknown breach id")
+	}
+
+	// so is a date whose day and month cannot be told apart
+	writeFile(t, dir, "meta.psv", "breach_id;date_discovered\nBREACH-001;01/02/2023\nBREACH-002;\n")
+	if stderr, err := run(t, dir, "-c", "-d", ";", "-breaches", "meta.psv", "-o", "out2", "-m", "map2.psv", "in.psv"); err == nil || !strings.Contains(stderr, "ambiguous date") {
+		t.Errorf("ambiguous date: got %v, %s", err, stderr)
+	}
+}
+
+func TestByBreachEmpty(t *testing.T) {
+	dir := t.TempDir()
+	mustRun(t, dir, "gen", "-c", "-n", "1", "-seed", "1", "all.psv")
+	data, err := os.ReadFile(filepath.Join(dir, "all.psv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, _ := strings.Cut(string(data), "\n")
+	writeFile(t, dir, "in.psv", header+"\n")
+	mustRun(t, dir, "-c", "-by-breach", "-o", "out", "-m", "maps/map.psv", "in.psv")
+
+	// without breaches the output is a single empty part
+	manifest := readRows(t, dir, filepath.Join("out", ManifestFile))
+	if want := []string{"in.psv", "record", "0"}; !reflect.DeepEqual(manifest[1], want) {
+		t.Errorf("manifest counts %q, want %q", manifest[1], want)
+	}
+	if rows := readRows(t, dir, "maps/map.psv"); len(rows) != 1 {
+		t.Errorf("map has %d rows, want only the header", len(rows)-1)
+	}
+	if stats := readRows(t, dir, "out/"+BreachStatsFile); len(stats) != 1 {
+		t.Errorf("statistics list %d breaches, want none", len(stats)-1)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"xor/lib/idfactor"
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+)
+
+var eraseUsage = func() {
+	str := `usage: idfactor erase [-c] [-e directory] [-x crosswalk] [-p provenance]
+                       [-ed delimiter] [-quote policy [-escape char]] [-eol lf|crlf]
+                       [-audit file] -ids file map...
+
+Erase records from a delivery, for example to honor a right to erasure
+request, without factoring it again.
+
+The record ids to erase are read from the file named by -ids, one per line,
+or from the standard input if it is -. Each map is a full identity map or a
+narrow map of a single element type written with -split-map, and every map of
+the delivery should be given so that no map keeps an erased record. Erase
+refuses to run unless the maps cover every element type of the delivery, as
+listed by its manifest or, without one, as found in the element files. The rows
+of the erased records are removed from the maps, and their elements from the
+element files, which are read from the current working directory unless
+another directory is specified with -e. An element that a record which is not
+erased also refers to is kept. If -c is specified then the element files are
+those of compromised entity input. If the maps hold surrogate record ids then
+-x names the crosswalk: the record ids to erase are source record ids, and
+their rows are removed from the crosswalk as well. If -p names the
+provenance file of the delivery then the rows of the erased records are
+removed from it too. If the files were written with another -od delimiter,
+-quote policy, -escape character or -eol line terminator then -ed, -quote,
+-escape and -eol give them.
+
+The row counts of the manifest in the element directory, if there is one,
+are reduced by the erased rows. The erased records are taken from the counts
+of the inputs they were read from, as given by the provenance. Without -p,
+or for a record the provenance does not list, they are taken from the only
+input of the manifest, or else the manifest counts the records of all inputs
+together in a single * row.
+
+Changed files are rewritten in the same format, and replaced together only
+once every one is complete.
+
+Every erased record and element, and every record id not found in the maps,
+is added with the time of the erasure to the audit record named by -audit,
+which is readable only by its owner and is updated together with the erased
+files.
+
+`
+	fmt.Fprint(os.Stderr, str)
+	flag.CommandLine.PrintDefaults()
+}
+
+func eraseMain(args []string) {
+	var (
+		dir           string
+		crosswalk     string
+		provenance    string
+		elementDelim  string
+		quote         string
+		escape        string
+		eol           string
+		idsFile       string
+		audit         string
+		isCompromised bool
+	)
+
+	flag.CommandLine = flag.NewFlagSet("idfactor erase", flag.ExitOnError)
+	flag.StringVar(&elementDelim, "ed", "|", "field `delimiter` of the maps, crosswalk and element files; \\t for tab")
+	flag.StringVar(&quote, "quote", "minimal", "quote `policy` of the maps, crosswalk and element files: "+strings.Join(idfactor.QuotePolicies, ", "))
+	flag.StringVar(&escape, "escape", `\`, "escape `character` of the files under -quote never")
+	flag.StringVar(&eol, "eol", "lf", "line `terminator` of the files: lf or crlf")
+	flag.StringVar(&dir, "e", "", "erase identity elements in the named `directory`")
+	flag.StringVar(&crosswalk, "x", "", "erase source record ids using the named crosswalk `file`")
+	flag.StringVar(&provenance, "p", "", "erase records from the named provenance `file`")
+	flag.StringVar(&idsFile, "ids", "", "read the record ids to erase from the named `file`")
+	flag.StringVar(&audit, "audit", "erasures.psv", "add the erased records and elements to the named audit `file`")
+	flag.BoolVar(&isCompromised, "c", false, "use compromised entity input format")
+	flag.Usage = eraseUsage
+	flag.CommandLine.Parse(args)
+
+	if flag.NArg() == 0 || idsFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	elements := atrisk.Elements
+	if isCompromised {
+		elements = compromised.Elements
+	}
+	format, err := parseFormat(elementDelim, quote, escape, eol)
+	if err != nil {
+		log.Fatalf("bad element format: %s", err)
+	}
+
+	// read the record ids to erase
+	var recordIDs []string
+	read := func(r io.Reader) error {
+		recordIDs, err = readRecordIDs(r)
+		return err
+	}
+	if idsFile == "-" {
+		err = read(os.Stdin)
+	} else {
+		err = readFile(idsFile, read)
+	}
+	if err != nil {
+		log.Fatalf("error reading record ids: %s", err)
+	}
+
+	// map source record ids to surrogate ids
+	mapIDs := recordIDs
+	sourceIDs := make(map[string]string)
+	if crosswalk != "" {
+		var surrogates map[string]string
+		err := readFile(crosswalk, func(r io.Reader) (err error) {
+			surrogates, err = idfactor.ReadCrosswalk(r, format)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("error reading crosswalk %s: %s", crosswalk, err)
+		}
+		bySource := make(map[string]string, len(surrogates))
+		for surrogate, recordID := range surrogates {
+			bySource[recordID] = surrogate
+			sourceIDs[surrogate] = recordID
+		}
+		mapIDs = make([]string, len(recordIDs))
+		for i, id := range recordIDs {
+			mapIDs[i] = bySource[id]
+			if mapIDs[i] == "" {
+				// not in the crosswalk, so not found in the maps
+				mapIDs[i] = id
+				sourceIDs[id] = id
+			}
+		}
+	}
+
+	// merge the maps
+	m := idfactor.NewIdentityMap()
+	m.Format = format
+	for _, name := range flag.Args() {
+		if err := readFile(name, m.Read); err != nil {
+			log.Fatalf("error reading map %s: %s", name, err)
+		}
+	}
+
+	// an element type without a map would keep the elements of the erased
+	// records, so every element type of the delivery must have one
+	var manifest idfactor.Manifest
+	err = readPriorFile(idfactor.DirFS{Dir: dir}, ManifestFile, func(r io.Reader) (err error) {
+		manifest, err = idfactor.ReadManifest(r, format)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("error reading manifest: %s", err)
+	}
+	for _, e := range elements {
+		if !m.Columns[e.ID] && delivered(manifest, dir, e) {
+			log.Fatalf("no map of %s elements given: every map of the delivery is needed to erase records from it", e.Name)
+		}
+	}
+	erasure := m.Erase(mapIDs, elements)
+	if crosswalk != "" {
+		for i := range erasure.Audit {
+			erasure.Audit[i].RecordID = sourceIDs[erasure.Audit[i].RecordID]
+		}
+	}
+
+	// rewrite the files and the audit record in one transaction
+	ctx := context.Background()
+	tx := &idfactor.Transaction{Overwrite: true}
+	config := func(name string) idfactor.Config {
+		return idfactor.Config{
+			FS:     idfactor.DirFS{Dir: filepath.Dir(name)},
+			Tx:     tx,
+			Format: format,
+		}
+	}
+	erase := func(name, column string, ids map[string]bool) int {
+		c := config(name)
+		n, err := c.EraseFromFileContext(ctx, filepath.Base(name), column, ids)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("error erasing from %s: %s", name, err)
+		}
+		return n
+	}
+	var maps int
+	for _, name := range flag.Args() {
+		maps += erase(name, idfactor.MapHeader[0], erasure.RecordIDs)
+	}
+	var elementRows int
+	erasedRows := make(map[string]int)
+	for _, e := range elements {
+		name := filepath.Join(dir, fileNames[e.Name])
+		if !m.Columns[e.ID] {
+			continue
+		}
+		n := erase(name, e.ID, erasure.ElementIDs)
+		erasedRows[fileNames[e.Name]] = n
+		elementRows += n
+	}
+	erasedInputs := make(map[string]int)
+	if provenance != "" {
+		sources, err := readProvenance(provenance, format)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("error reading provenance %s: %s", provenance, err)
+		}
+		for id := range erasure.RecordIDs {
+			erasedInputs[sources[id]]++
+		}
+		erase(provenance, idfactor.ProvenanceHeader[0], erasure.RecordIDs)
+	} else {
+		erasedInputs[""] = len(erasure.RecordIDs)
+	}
+	if manifest != nil {
+		manifest, err = manifest.Erase(erasedInputs, erasedRows)
+		if err == nil {
+			manifestConfig := config(filepath.Join(dir, ManifestFile))
+			err = manifestConfig.WriteManifestToFileContext(ctx, manifest, ManifestFile)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("error updating manifest: %s", err)
+		}
+	}
+	if crosswalk != "" {
+		erased := make(map[string]bool)
+		for id := range erasure.RecordIDs {
+			erased[sourceIDs[id]] = true
+		}
+		erase(crosswalk, idfactor.CrosswalkHeader[0], erased)
+	}
+	auditConfig := config(audit)
+	if err := auditConfig.WriteAuditToFileContext(ctx, erasure.Audit, time.Now(), filepath.Base(audit)); err != nil {
+		tx.Rollback()
+		log.Fatalf("error writing audit record: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("error writing output: %s", err)
+	}
+
+	log.Printf("erased %d of %d records: %d map rows and %d elements", len(erasure.RecordIDs), len(recordIDs), maps, elementRows)
+}
+
+// readProvenance reads a provenance file and returns the source of each record
+// id
+func readProvenance(name string, format idfactor.Format) (map[string]string, error) {
+	var rows [][]string
+	err := readFile(name, func(r io.Reader) (err error) {
+		rows, err = format.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || !slices.Equal(rows[0], idfactor.ProvenanceHeader) {
+		return nil, errors.New("bad provenance header")
+	}
+	sources := make(map[string]string, len(rows)-1)
+	for _, row := range rows[1:] {
+		sources[row[0]] = row[1]
+	}
+	return sources, nil
+}
+
+// delivered reports whether a delivery has elements of type e: whether its
+// manifest lists them or, without a manifest, whether their element file is
+// in dir
+func delivered(manifest idfactor.Manifest, dir string, e idfactor.Element) bool {
+	if manifest == nil {
+		_, err := os.Stat(filepath.Join(dir, fileNames[e.Name]))
+		return err == nil
+	}
+	for _, entry := range manifest {
+		if entry.Element == e.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// readRecordIDs reads record ids, one per line, skipping empty lines and a
+// record_id header
+func readRecordIDs(r io.Reader) ([]string, error) {
+	var recordIDs []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || (line == 1 && id == idfactor.MapHeader[0]) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		recordIDs = append(recordIDs, id)
+	}
+	return recordIDs, scanner.Err()
+}
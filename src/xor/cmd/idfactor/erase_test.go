@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestErase(t *testing.T) {
+	dir := t.TempDir()
+	mustRun(t, dir, "gen", "-n", "6", "-seed", "1", "-dup", "0", "-empty", "0", "all.psv")
+	data, err := os.ReadFile(filepath.Join(dir, "all.psv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	writeFile(t, dir, "a.psv", strings.Join(lines[:4], ""))
+	writeFile(t, dir, "b.psv", lines[0]+strings.Join(lines[4:], ""))
+	mustRun(t, dir, "-o", "out", "-m", "map.psv", "-crosswalk-out", "cw.psv", "-provenance-out", "prov.psv", "a.psv", "b.psv")
+
+	// the maps and provenance hold surrogates of the source record ids
+	surrogates := make(map[string]string)
+	for _, row := range readRows(t, dir, "cw.psv")[1:] {
+		surrogates[row[0]] = row[1]
+	}
+	writeFile(t, dir, "ids", "RECORD-000000002\nRECORD-000000005\nRECORD-X\n")
+	mustRun(t, dir, "erase", "-e", "out", "-x", "cw.psv", "-p", "prov.psv", "-ids", "ids", "map.psv")
+
+	kept := []string{"RECORD-000000001", "RECORD-000000003", "RECORD-000000004", "RECORD-000000006"}
+	var keptSurrogates []string
+	for _, id := range kept {
+		keptSurrogates = append(keptSurrogates, surrogates[id])
+	}
+	slices.Sort(keptSurrogates)
+	column := func(name string, i int) []string {
+		var ids []string
+		for _, row := range readRows(t, dir, name)[1:] {
+			ids = append(ids, row[i])
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	for _, f := range []struct {
+		name   string
+		column int
+		want   []string
+	}{
+		{"cw.psv", 0, kept},
+		{"map.psv", 0, keptSurrogates},
+		{"prov.psv", 0, keptSurrogates},
+	} {
+		if got := column(f.name, f.column); !reflect.DeepEqual(got, f.want) {
+			t.Errorf("%s: got record ids %q, want %q", f.name, got, f.want)
+		}
+	}
+
+	// the manifest counts the erased records against their inputs
+	manifest := readRows(t, dir, "out/manifest.psv")
+	if want := [][]string{{"a.psv", "record", "2"}, {"b.psv", "record", "2"}, {"name_dob_elements.psv", "name_dob", "4"}}; !reflect.DeepEqual(manifest[1:4], want) {
+		t.Errorf("manifest starts %q, want %q", manifest[1:4], want)
+	}
+	if rows := readRows(t, dir, "out/ssn_elements.psv"); len(rows) != 5 {
+		t.Errorf("ssn elements: got %d rows, want 5", len(rows))
+	}
+
+	// the audit record names the source record ids
+	var erased []string
+	for _, row := range readRows(t, dir, "erasures.psv")[1:] {
+		if row[2] == "" {
+			erased = append(erased, row[1]+" "+row[4])
+		}
+	}
+	if want := []string{"RECORD-000000002 erased", "RECORD-000000005 erased", "RECORD-X not found"}; !reflect.DeepEqual(erased, want) {
+		t.Errorf("audit: got %q, want %q", erased, want)
+	}
+
+	// without the provenance the records of all inputs are counted together
+	writeFile(t, dir, "ids", "RECORD-000000001\n")
+	mustRun(t, dir, "erase", "-e", "out", "-x", "cw.psv", "-ids", "ids", "map.psv")
+	manifest = readRows(t, dir, "out/manifest.psv")
+	if want := [][]string{{"*", "record", "3"}, {"name_dob_elements.psv", "name_dob", "3"}}; !reflect.DeepEqual(manifest[1:3], want) {
+		t.Errorf("manifest starts %q, want %q", manifest[1:3], want)
+	}
+	// a record and its eight elements for each erased record, and one row
+	// for the record id not found
+	if rows := readRows(t, dir, "erasures.psv"); len(rows) != 1+3*9+1 {
+		t.Errorf("audit: got %d rows, want %d", len(rows), 1+3*9+1)
+	}
+}
+
+func TestEraseSplitMap(t *testing.T) {
+	dir := t.TempDir()
+	mustRun(t, dir, "gen", "-n", "3", "-seed", "1", "a.psv")
+	mustRun(t, dir, "-o", "out", "-m", "map.psv", "-split-map", "a.psv")
+	manifest, err := os.ReadFile(filepath.Join(dir, "out", "manifest.psv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "ids", "RECORD-000000001\n")
+
+	// a single split map would leave the other element types unerased
+	if stderr, err := run(t, dir, "erase", "-e", "out", "-ids", "ids", "map_ssn.psv"); err == nil {
+		t.Fatal("erase with a single split map succeeded")
+	} else if !strings.Contains(stderr, "no map of") {
+		t.Errorf("unexpected error: %s", stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "erasures.psv")); !os.IsNotExist(err) {
+		t.Error("audit record written for a refused erasure")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "out", "manifest.psv")); string(data) != string(manifest) {
+		t.Error("manifest changed by a refused erasure")
+	}
+
+	// every split map erases the record
+	maps, err := filepath.Glob(filepath.Join(dir, "map_*.psv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range maps {
+		maps[i] = filepath.Base(maps[i])
+	}
+	mustRun(t, dir, append([]string{"erase", "-e", "out", "-ids", "ids"}, maps...)...)
+	if rows := readRows(t, dir, "out/ssn_elements.psv"); len(rows) != 3 {
+		t.Errorf("ssn elements: got %d rows, want 3", len(rows))
+	}
+}
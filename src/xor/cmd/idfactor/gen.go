@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"xor/lib/synth"
+)
+
+var genUsage = func() {
+	str := `usage: idfactor gen [-c] [-b breaches] [-n count] [-dup rate] [-empty rate] [-seed n] [-d delimiter] [file]
+
+Generate synthetic identity records for testing and benchmarking.
+
+The generated names, dates of birth, addresses, phone numbers, email addresses
+and usernames are plausible but fictitious. SSNs are valid in format but drawn
+from the never issued 9xx area range, phone numbers use the 555-01xx range
+reserved for fictional use, and email addresses use the reserved example
+domains. If -c is specified then compromised entity input format is written.
+
+Records are written to the named file, or to the standard output if no file is
+supplied. The same -seed always generates the same records; without it a seed
+is picked from the current time and reported on the standard error, so that
+the records can be generated again.
+
+`
+	fmt.Fprint(os.Stderr, str)
+	flag.CommandLine.PrintDefaults()
+}
+
+func genMain(args []string) {
+	var (
+		delim string
+		n     int
+		cfg   synth.Config
+	)
+
+	flag.CommandLine = flag.NewFlagSet("idfactor gen", flag.ExitOnError)
+	flag.StringVar(&delim, "d", "|", "field `delimiter` for the output file; \\t for tab")
+	flag.IntVar(&n, "n", 1000, "number of records to generate")
+	flag.BoolVar(&cfg.Compromised, "c", false, "use compromised entity input format")
+	flag.IntVar(&cfg.Breaches, "b", 10, "number of distinct breach ids in compromised format")
+	flag.Float64Var(&cfg.DuplicateRate, "dup", 0.05, "probability that an identity element duplicates a recent record")
+	flag.Float64Var(&cfg.EmptyRate, "empty", 0.02, "probability that a field is empty")
+	flag.Int64Var(&cfg.Seed, "seed", 0, "random seed; 0 picks a seed from the current time")
+	flag.Usage = genUsage
+	flag.CommandLine.Parse(args)
+
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+		log.Printf("generating records with -seed %d", cfg.Seed)
+	}
+	comma, err := parseRune(delim)
+	if err != nil {
+		log.Fatalf("bad delimiter: %s", err)
+	}
+	if n < 0 {
+		log.Fatal("number of records must not be negative")
+	}
+	if cfg.DuplicateRate < 0 || cfg.DuplicateRate > 1 || cfg.EmptyRate < 0 || cfg.EmptyRate > 1 {
+		log.Fatal("rates must be between 0 and 1")
+	}
+
+	// write to stdout or file
+	var out io.WriteCloser = os.Stdout
+	if flag.Arg(0) != "" {
+		file, err := os.Create(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("error creating output file: %s", err)
+		}
+		out = file
+	}
+	w := bufio.NewWriter(out)
+	if err := synth.Write(w, cfg, n, comma); err != nil {
+		log.Fatalf("error writing records: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("error writing records: %s", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("error closing file: %s", err)
+	}
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
-	"encoding/csv"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
+	"xor/lib/csprng"
 	"xor/lib/idfactor"
 	"xor/lib/idfactor/atrisk"
 	"xor/lib/idfactor/compromised"
+	"xor/lib/ids"
+	"xor/lib/input"
+	"xor/lib/s3"
 )
 
 // Output file names
@@ -23,86 +41,536 @@ const (
 	NameAddressFile = "name_address_elements.psv"
 	NamePhoneFile   = "name_phone_elements.psv"
 	UserNameFile    = "username_elements.psv"
+	ManifestFile    = "manifest.psv"
+	BreachesFile    = "breaches.psv"
+	BreachStatsFile = "breach_stats.psv"
 )
 
+// fileNames maps element type names to output file names
+var fileNames = map[string]string{
+	"name_dob":     NameDobFile,
+	"ssn":          SsnFile,
+	"address":      AddressFile,
+	"phone":        PhoneFile,
+	"email":        EmailFile,
+	"name_address": NameAddressFile,
+	"name_phone":   NamePhoneFile,
+	"username":     UserNameFile,
+}
+
+// ProductionMarker is the name of a file that marks a directory as a
+// production output directory. Seeded runs refuse to write to such a
+// directory unless forced.
+const ProductionMarker = ".idfactor-production"
+
 //------------------------------------------------------------------------------
-// ID factoring for at-risk entities
+// ID factoring
 //------------------------------------------------------------------------------
 
-func AtRiskNameDob(recs [][]string) map[string]string {
-	return atrisk.WriteNameDobFile(recs, NameDobFile)
+// seedTime is used in place of the current time by time based element ids in
+// seeded runs
+var seedTime = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// IDFactoring writes each type of identity element in the given records to
+// its own file using the given configuration and returns the identity map.
+// The files are written to the subdirectory dir of the output directory, if
+// dir is not empty. If seeded is true then the output is entirely determined
+// by seed and is insecure.
+func IDFactoring(ctx context.Context, recs [][]string, elements []idfactor.Element, config idfactor.Config, seeded bool, seed int64, dir string) ([][]string, error) {
+	factorers := make([]idfactor.FactorerContext, len(elements))
+	for i, e := range elements {
+		// each factorer runs concurrently and needs its own source
+		config := config
+		if seeded {
+			config.Rand = csprng.NewInsecureStream(seed, path.Join(dir, e.Name))
+		}
+		factorers[i] = config.FactorerContext(e, path.Join(dir, fileNames[e.Name]))
+	}
+	return idfactor.IDFactorContext(ctx, recs, factorers...)
 }
 
-func AtRiskSsn(recs [][]string) map[string]string {
-	return atrisk.WriteSsnFile(recs, SsnFile)
+// appendSeed returns the seed of a seeded run that appends to the output of
+// prior runs, which mixes the record ids of their map into the given seed so
+// that each run generates new element ids
+func appendSeed(seed int64, prior *idfactor.IdentityMap) int64 {
+	if len(prior.RecordIDs) == 0 {
+		return seed
+	}
+	recordIDs := slices.Sorted(slices.Values(prior.RecordIDs))
+	h := sha256.New()
+	for _, id := range recordIDs {
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+	}
+	return seed ^ int64(binary.BigEndian.Uint64(h.Sum(nil)))
 }
 
-func AtRiskAddress(recs [][]string) map[string]string {
-	return atrisk.WriteAddressFile(recs, AddressFile)
+// outputFS returns the file system for an output destination, which is either
+// a local directory or an s3://bucket/prefix URL
+func outputFS(dest string, perm os.FileMode, sse, kmsKey string) (idfactor.FS, error) {
+	if !strings.HasPrefix(dest, "s3://") {
+		return idfactor.DirFS{Dir: dest, Perm: perm}, nil
+	}
+	bucket, prefix, err := s3.ParseURL(dest)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s3.ClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &s3.FS{Client: client, Bucket: bucket, Prefix: prefix, SSE: sse, KMSKeyID: kmsKey}, nil
 }
 
-func AtRiskPhone(recs [][]string) map[string]string {
-	return atrisk.WritePhoneFile(recs, PhoneFile)
+// splitDest splits a file destination, which is either a local path or an
+// s3://bucket/prefix/name URL, into its directory and file name
+func splitDest(dest string) (dir, name string) {
+	if strings.HasPrefix(dest, "s3://") {
+		i := strings.LastIndex(dest, "/")
+		if i < len("s3://") {
+			return dest, ""
+		}
+		return dest[:i], dest[i+1:]
+	}
+	if strings.HasSuffix(dest, "/") || strings.HasSuffix(dest, string(filepath.Separator)) {
+		return dest, ""
+	}
+	return filepath.Dir(dest), filepath.Base(dest)
 }
 
-func AtRiskEmail(recs [][]string) map[string]string {
-	return atrisk.WriteEmailFile(recs, EmailFile)
+// fileDest is the destination of a single output file. A nil fs means the
+// standard output.
+type fileDest struct {
+	dir  string
+	name string
+	fs   idfactor.FS
 }
 
-func AtRiskNameAddress(recs [][]string) map[string]string {
-	return atrisk.WriteNameAddressFile(recs, NameAddressFile)
+// openDest opens the destination of a file that re-links the identity
+// elements, which is either a local path, an s3://bucket/prefix/name URL, or -
+// for the standard output. Unless allow is true it refuses the directory of
+// the element files.
+func openDest(dest, elementDir string, allow bool, perm os.FileMode, sse, kmsKey string) (fileDest, error) {
+	if dest == "-" {
+		return fileDest{}, nil
+	}
+	dir, name := splitDest(dest)
+	if name == "" {
+		return fileDest{}, fmt.Errorf(`destination "%s" has no file name`, dest)
+	}
+	if sameDest(dir, elementDir) && !allow {
+		return fileDest{}, fmt.Errorf(`refusing to write to the element file directory "%s" (use -allow-map-with-elements to override)`, dir)
+	}
+	fs, err := outputFS(dir, perm, sse, kmsKey)
+	if err != nil {
+		return fileDest{}, err
+	}
+	return fileDest{dir, name, fs}, nil
 }
 
-func AtRiskNamePhone(recs [][]string) map[string]string {
-	return atrisk.WriteNamePhoneFile(recs, NamePhoneFile)
+// elementMapName returns the name of the narrow identity map of an element
+// type given the name of the full identity map, e.g. map_ssn.psv for map.psv
+func elementMapName(name string, e idfactor.Element) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + e.Name + ext
 }
 
-func AtRiskUserName(recs [][]string) map[string]string {
-	return atrisk.WriteUserNameFile(recs, UserNameFile)
+// sameDest reports whether two output destinations refer to the same
+// directory or s3 prefix
+func sameDest(a, b string) bool {
+	if strings.HasPrefix(a, "s3://") || strings.HasPrefix(b, "s3://") {
+		bucketA, prefixA, errA := s3.ParseURL(a)
+		bucketB, prefixB, errB := s3.ParseURL(b)
+		return errA == nil && errB == nil && bucketA == bucketB && prefixA == prefixB
+	}
+	return canonicalDir(a) == canonicalDir(b)
 }
 
-func AtRiskIDFactoring(recs [][]string) (idmap [][]string, err error) {
-	return idfactor.IDFactor(recs, AtRiskNameDob, AtRiskSsn, AtRiskAddress, AtRiskPhone, AtRiskEmail, AtRiskNameAddress, AtRiskNamePhone, AtRiskUserName)
+// canonicalDir returns the absolute path of a local directory with any
+// symbolic links resolved, as far as that is possible
+func canonicalDir(dir string) string {
+	if dir == "" {
+		dir = "."
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	return dir
 }
 
-//------------------------------------------------------------------------------
-// ID factoring for compromised entities
-//------------------------------------------------------------------------------
+// parseRune parses a single character, or \t for tab
+func parseRune(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if s == "" || size != len(s) || r == utf8.RuneError {
+		return 0, fmt.Errorf(`"%s" is not a single character`, s)
+	}
+	return r, nil
+}
+
+// parseFormat parses the delimiter, quote policy, escape character and line
+// terminator of a file format
+func parseFormat(delim, quote, escape, eol string) (idfactor.Format, error) {
+	var format idfactor.Format
+	var err error
+	if format.Comma, err = parseRune(delim); err != nil {
+		return format, fmt.Errorf("bad delimiter: %w", err)
+	}
+	if format.Quote, err = idfactor.ParseQuotePolicy(quote); err != nil {
+		return format, err
+	}
+	if format.Escape, err = parseRune(escape); err != nil {
+		return format, fmt.Errorf("bad escape character: %w", err)
+	}
+	switch eol {
+	case "lf":
+	case "crlf":
+		format.CRLF = true
+	default:
+		return format, fmt.Errorf(`invalid line terminator "%s"`, eol)
+	}
+	return format, format.Validate()
+}
+
+// expandInputs expands input arguments into the input files of a run. A
+// directory stands for the regular files in it, other than hidden files, and
+// a glob pattern for the files it matches. With no arguments, or -, the
+// standard input is read, and is named -.
+func expandInputs(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return []string{"-"}, nil
+	}
+	var inputs []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[filepath.Clean(name)] {
+			seen[filepath.Clean(name)] = true
+			inputs = append(inputs, name)
+		}
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			add(arg)
+			continue
+		}
+		paths := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf(`bad pattern "%s": %w`, arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf(`no files match "%s"`, arg)
+			}
+			paths = matches
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+					add(filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+	return inputs, nil
+}
+
+// readOptions are the settings with which input files are read
+type readOptions struct {
+	dialect         input.Dialect
+	encoding        string
+	fieldsPerRecord int
+	tolerant        bool
+	reject          func(input.Reject) error
+}
+
+// readInput reads the header and records of the named input file, and the
+// line on which each record starts, transcoding it to UTF-8 and parsing it as
+// given by opts. If named is true then log messages name the file.
+func readInput(name string, opts readOptions, named bool) ([]string, [][]string, []int, error) {
+	var in io.ReadCloser = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		in = file
+	}
+	prefix := ""
+	if named {
+		prefix = name + ": "
+	}
+	decoder, err := input.NewDecoder(in, opts.encoding)
+	if err != nil {
+		in.Close()
+		return nil, nil, nil, err
+	}
+	if decoder.Detected && decoder.Encoding != input.UTF8 {
+		log.Printf("%sinput encoding detected as %s", prefix, decoder.Encoding)
+	}
+	reader := input.NewReader(decoder)
+	reader.Dialect = opts.dialect
+	reader.FieldsPerRecord = opts.fieldsPerRecord
+	reader.Tolerant = opts.tolerant
+	reader.Reject = opts.reject
+	reader.Name = name
+	header, recs, err := reader.ReadAll()
+	if err != nil {
+		in.Close()
+		return nil, nil, nil, detectHint(err)
+	}
+	if decoder.Invalid > 0 {
+		log.Printf("%s%d invalid %s sequences in input, the first on line %d", prefix, decoder.Invalid, decoder.Encoding, decoder.FirstInvalidLine)
+	}
+	return header, recs, reader.Lines(), in.Close()
+}
 
-func CompromisedNameDob(recs [][]string) map[string]string {
-	return compromised.WriteNameDobFile(recs, NameDobFile)
+// detectHint suggests declaring the encoding of input that was wrongly
+// detected as UTF-8
+func detectHint(err error) error {
+	if errors.Is(err, input.ErrDetect) {
+		return fmt.Errorf("%w (use -encoding %s if the input is not UTF-8)", err, input.Windows1252)
+	}
+	return err
 }
 
-func CompromisedSsn(recs [][]string) map[string]string {
-	return compromised.WriteSsnFile(recs, SsnFile)
+// readBreaches reads the named breach metadata file in the dialect and
+// encoding of the input
+func readBreaches(name string, opts readOptions) (map[string]compromised.Breach, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder, err := input.NewDecoder(file, opts.encoding)
+	if err != nil {
+		return nil, err
+	}
+	breaches, err := compromised.ReadBreaches(decoder, opts.dialect)
+	if err != nil {
+		return nil, detectHint(err)
+	}
+	if decoder.Invalid > 0 {
+		log.Printf("%s: %d invalid %s sequences in breach metadata, the first on line %d", name, decoder.Invalid, decoder.Encoding, decoder.FirstInvalidLine)
+	}
+	return breaches, nil
 }
 
-func CompromisedAddress(recs [][]string) map[string]string {
-	return compromised.WriteAddressFile(recs, AddressFile)
+// inputNames returns a name for each input file that does not reveal local
+// paths but tells the inputs apart: the file name, or as many of the
+// directories above it as needed to make it unique.
+func inputNames(inputs []string) []string {
+	elems := make([][]string, len(inputs))
+	depth := make([]int, len(inputs))
+	for i, name := range inputs {
+		elems[i] = strings.Split(filepath.ToSlash(filepath.Clean(name)), "/")
+		depth[i] = 1
+	}
+	names := make([]string, len(inputs))
+	for {
+		count := make(map[string]int)
+		for i, e := range elems {
+			names[i] = strings.Join(e[len(e)-depth[i]:], "/")
+			count[names[i]]++
+		}
+		deeper := false
+		for i, e := range elems {
+			if count[names[i]] > 1 && depth[i] < len(e) {
+				depth[i]++
+				deeper = true
+			}
+		}
+		if !deeper {
+			return names
+		}
+	}
 }
 
-func CompromisedPhone(recs [][]string) map[string]string {
-	return compromised.WritePhoneFile(recs, PhoneFile)
+// readPrior reads the identity map written by prior runs in the given format
+// to the given map destination, split into element maps if split is true, and
+// the record ids they factored. If crosswalk is not nil then the map has
+// surrogate ids and the record ids are read from the crosswalk there. Missing
+// files are taken to be empty.
+func readPrior(dest fileDest, split bool, crosswalk *fileDest, elements []idfactor.Element, format idfactor.Format) (*idfactor.IdentityMap, []string, error) {
+	prior := idfactor.NewIdentityMap()
+	prior.Format = format
+	names := []string{dest.name}
+	if split {
+		names = names[:0]
+		for _, e := range elements {
+			names = append(names, elementMapName(dest.name, e))
+		}
+	}
+	for _, name := range names {
+		if err := readPriorFile(dest.fs, name, prior.Read); err != nil {
+			return nil, nil, err
+		}
+	}
+	if crosswalk == nil {
+		return prior, prior.RecordIDs, nil
+	}
+	var recordIDs []string
+	err := readPriorFile(crosswalk.fs, crosswalk.name, func(r io.Reader) error {
+		ids, err := idfactor.ReadCrosswalk(r, format)
+		for _, id := range ids {
+			recordIDs = append(recordIDs, id)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return prior, recordIDs, nil
 }
 
-func CompromisedEmail(recs [][]string) map[string]string {
-	return compromised.WriteEmailFile(recs, EmailFile)
+// readPriorFile reads the named file in fs with read, if it exists
+func readPriorFile(fs idfactor.FS, name string, read func(r io.Reader) error) error {
+	r, err := fs.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := read(r); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
 }
 
-func CompromisedNameAddress(recs [][]string) map[string]string {
-	return compromised.WriteNameAddressFile(recs, NameAddressFile)
+// part is the output of a run, or of one breach when compromised entity output
+// is partitioned by breach
+type part struct {
+	// dir is the subdirectory of the output, or empty
+	dir     string
+	records [][]string
+	sources []string
+	// inputs is the number of records of the part read from each input
+	inputs map[string]int
 }
 
-func CompromisedNamePhone(recs [][]string) map[string]string {
-	return compromised.WriteNamePhoneFile(recs, NamePhoneFile)
+// name returns the name of an output file of the part
+func (p part) name(file string) string {
+	return path.Join(p.dir, file)
 }
 
-func CompromisedUserName(recs [][]string) map[string]string {
-	return compromised.WriteUserNameFile(recs, UserNameFile)
+// newPart returns the part with the given subdirectory, records and input
+// file of each record
+func newPart(dir string, records [][]string, sources []string) part {
+	inputs := make(map[string]int)
+	for _, source := range sources {
+		inputs[source]++
+	}
+	return part{dir: dir, records: records, sources: sources, inputs: inputs}
 }
 
-func CompromisedIDFactoring(recs [][]string) (idmap [][]string, err error) {
-	return idfactor.IDFactor(recs, CompromisedNameDob, CompromisedSsn, CompromisedAddress, CompromisedPhone, CompromisedEmail, CompromisedNameAddress, CompromisedNamePhone, CompromisedUserName)
+// partitionByBreach splits compromised entity records, with the input file of
+// each, into one part per breach named after its breach id
+func partitionByBreach(records [][]string, sources []string) []part {
+	breachIDs, positions := compromised.Partition(records)
+	parts := make([]part, len(breachIDs))
+	for i, id := range breachIDs {
+		recs := make([][]string, len(positions[id]))
+		srcs := make([]string, len(positions[id]))
+		for k, j := range positions[id] {
+			recs[k], srcs[k] = records[j], sources[j]
+		}
+		parts[i] = newPart(id, recs, srcs)
+	}
+	return parts
+}
+
+// breachStatsHeader returns the column header of the breach statistics: the
+// breach id, its number of records and its number of elements of each type
+func breachStatsHeader(elements []idfactor.Element) []string {
+	header := []string{"breach_id", "records"}
+	for _, e := range elements {
+		header = append(header, e.Name)
+	}
+	return header
+}
+
+// breachStatsRow returns the breach statistics of the part of a breach, given
+// its manifest
+func breachStatsRow(p part, manifest idfactor.Manifest) []string {
+	row := []string{p.dir, strconv.Itoa(len(p.records))}
+	for _, entry := range manifest {
+		if entry.Element != "record" {
+			row = append(row, strconv.Itoa(entry.Rows))
+		}
+	}
+	return row
+}
+
+// breachRows returns the normalized metadata of the breaches of the given
+// compromised entity records, in breach id order
+func breachRows(records [][]string, breaches map[string]compromised.Breach) [][]string {
+	breachIDs, _ := compromised.Partition(records)
+	rows := make([][]string, len(breachIDs))
+	for i, id := range breachIDs {
+		rows[i] = breaches[id].Row()
+	}
+	return rows
+}
+
+// resolveSources returns the input files of the records that remain once
+// duplicate record ids are resolved, given the input file of each record
+// read and the collisions found
+func resolveSources(sources []string, collisions []idfactor.Collision) []string {
+	dropped := make(map[int]bool)
+	for _, c := range collisions {
+		if c.Action == "dropped" {
+			dropped[c.Record-1] = true
+		}
+	}
+	resolved := make([]string, 0, len(sources)-len(dropped))
+	for i, source := range sources {
+		if !dropped[i] {
+			resolved = append(resolved, source)
+		}
+	}
+	return resolved
+}
+
+// stageReport stages a report, such as the report of records with repeated
+// record ids, in tx as the named local file, which is readable only by its
+// owner. Reports hold input records, so they are only written together with
+// the output.
+func stageReport(tx *idfactor.Transaction, name string, report []byte) error {
+	file, err := tx.Create(idfactor.DirFS{}, name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// isProduction reports whether the given output file system is marked as a
+// production destination
+func isProduction(fs idfactor.FS) bool {
+	exists, err := fs.Exists(ProductionMarker)
+	return exists || err != nil
 }
 
 //------------------------------------------------------------------------------
@@ -110,22 +578,182 @@ func CompromisedIDFactoring(recs [][]string) (idmap [][]string, err error) {
 //------------------------------------------------------------------------------
 
 var usage = func() {
-	str := `usage: idfactor [-c] [-d delimiter] [-m file] [-o directory] [file]
+	str := `usage: idfactor [-c] [-d delimiter] [-map-out destination [-split-map] [-crosswalk-out destination]
+                [-provenance-out destination] [-allow-map-with-elements]] [-append] [-duplicates policy] [-duplicates-report file]
+                [-input-quote char|none] [-input-escape char]
+                [-tolerant [-rejects file]] [-encoding name]
+                [-od delimiter] [-quote policy [-escape char]] [-eol lf|crlf]
+                [-o directory] [-by-breach] [-breaches file] [-id scheme [-random-time]]
+                [-seed n [-force-seed]] [-timeout duration] [-force] [-perm mode]
+                [-sse algorithm [-sse-kms-key id]] [file|directory|pattern ...]
+       idfactor gen [flags] [file]
+       idfactor reconstruct [flags] map...
+       idfactor erase [flags] -ids file map...
 
 Split each identity record into pieces and output them in shuffled order.
 
 The input file is a delimited text file with column headers where each row
 contains a full identity record consisting of a unique record identifier
 followed by name, date of birth, ssn, address, phone number, and email address
-fields. If a file is not supplied then it is read from the standard input.
+fields. If a file is not supplied, or is -, then it is read from the standard
+input.
+
+Any number of input files may be given, as paths, directories, which stand
+for the regular files in them other than hidden files, and glob patterns such
+as 'intake/*.psv', quoted so that the shell leaves them alone. Every input
+must have the same header. The records of all the inputs are factored in one
+run, so the element files are shuffled across all of them. With
+-provenance-out the input file of each record id in the map, named as in the
+manifest, is written to its own destination, which takes the same forms as
+-map-out.
+
 Additionally, if -c is specified then compromised entity input format is
 assumed. This formats adds a breach identifier after the record identifier.
 
+Input fields are pipe delimited unless -d gives another delimiter, which may be
+any Unicode character, \t for tab, or a string of several characters such as
+||. Fields may be quoted with double quotes, doubled inside a quoted field, or
+with the character given by -input-quote, or never if it is none. With
+-input-escape the given character makes the character after it literal, so an
+escaped delimiter, quote or line break is read as part of the field; an
+escaped n or r is read as a line feed or carriage return, which reads output
+written with -quote never.
+
+Input is transcoded to UTF-8 from the encoding given by -encoding. By default
+the encoding is taken from a byte order mark, which is removed, or else
+detected from the first 64 KiB of input: UTF-8 if it is valid UTF-8, UTF-16
+if it looks like it, and Windows-1252 otherwise, which reads Latin-1 text the
+same way. Input detected as UTF-8 that has invalid UTF-8 later on stops the
+run; give -encoding windows-1252 to read it.
+Invalid sequences are reported. In UTF-8 input they are left in place, so that
+their rows are rejected as invalid UTF-8, and in other encodings they are
+replaced with the Unicode replacement character.
+
+A malformed row, with the wrong number of fields, bad quoting or invalid UTF-8,
+stops the run unless -tolerant is given. Each line is then read as one record,
+so quoted fields may not span lines, and malformed rows are skipped and
+summarized at the end. With -rejects they are also written, with their input
+files, line numbers and the reasons they were rejected, to a file readable
+only by its owner, which is written only if the run succeeds. The header must
+be well formed even with -tolerant.
+
+Every record must have its own record id. Input with repeated record ids is
+rejected unless -duplicates says how to resolve them: first and last keep only
+the first or last record with each id, while suffix keeps every record and
+renames the second and later ones by appending -2, -3, ... to the id. The
+number of affected records is always reported; -duplicates-report writes the
+record ids, record numbers, input files, line numbers and actions to a file
+readable only by its owner. The report is written only if the run succeeds,
+or if the repeated record ids stop it.
+
 Output files are written to the current working directory unless an output
-directory is specified with -o.
+directory is specified with -o. The output directory may also be an S3
+compatible object storage location of the form s3://bucket/prefix, in which
+case credentials, region and endpoint are taken from the standard AWS_*
+environment variables and -sse requests server side encryption.
+
+A manifest, ` + ManifestFile + `, is written with the element files. It lists
+each input and element file with its number of rows. Inputs are named by
+their file names, with as many of the directories above them as are needed to
+tell them apart. Input with no records, even without a header, yields element
+files and a map with only their headers and a manifest stating zero records.
+
+In compromised entity mode -by-breach partitions the output by breach id:
+the element files, manifest, map, crosswalk and provenance of each breach are
+written to a subdirectory named after it, under the output directory and
+under the map, crosswalk and provenance destinations, so that each breach can
+be retained and shared under its own rules. Breach ids must then be made of
+letters, digits, dots, hyphens and underscores. The output directory itself
+gets ` + BreachStatsFile + `, which lists each breach with its number of
+records and its number of elements of each type. Input without records has
+no breaches, and its empty output is written as without -by-breach.
+-by-breach cannot be combined with -append or with the standard output.
 
-Optionally specify -m to write a map file that can be used to reconstruct the
-full identity record from the identity elements.
+In compromised entity mode -breaches names a breach metadata file, delimited,
+quoted and encoded like the input, with a header naming any of the columns
+breach_id, name, date_discovered, date_of_breach, source and data_classes, of
+which only breach_id is required. Dates with the day and month separated by
+slashes, as in 03/04/2023, are refused unless the day is over 12 or equal to
+the month, since their order differs between countries. A run whose input has a breach id missing from the
+metadata is refused and the unknown ids are reported. The metadata of the
+breaches in the input is written, with dates as YYYY-MM-DD and data classes
+as a sorted list separated by semicolons, to ` + BreachesFile + ` with the
+element files, and with -by-breach to each breach subdirectory as well.
+
+Output files are written under temporary names and renamed into place only
+once every file is complete, so a failed run leaves no partial output. Existing
+output files are not overwritten unless -force is given. If -timeout is given,
+or the process is interrupted or terminated, factoring stops and the temporary
+files are overwritten and removed.
+
+Output files, the rejects file and the duplicates report included, are pipe
+delimited with LF line endings on every platform unless -od and -eol say
+otherwise. Fields are quoted only where needed unless -quote
+is always, which quotes every field, or never, which quotes none and instead
+escapes the delimiter, line breaks and the escape character itself with the
+-escape character.
+
+Output files are readable only by their owner unless -perm gives another mode,
+and a missing output directory is created readable only by its owner.
+
+With -append the output of a run is added to the output of earlier runs with
+the same destinations, so that a regular feed builds up one delivery: the
+new rows of each file are shuffled in with its existing ones, so that the
+rows of a run cannot be told apart from those of earlier runs, and the file
+is replaced as a whole when the run succeeds. The crosswalk is kept sorted by
+record id instead. Existing files must have the same header and format.
+Append mode needs a -map-out file, from which, or from the crosswalk if
+-crosswalk-out is given, the record ids of earlier runs are read. A record
+that reuses one of them is handled by -duplicates as if the earlier record
+came first in the input, except that last is refused because delivered
+records cannot be replaced. A run that generates an id already in the map is
+abandoned. Element ids are always new, so an element seen in an earlier run
+is delivered again with a new id: reusing the ids of delivered elements would
+need keyed element ids, which idfactor does not support. The manifest is
+replaced by one that counts the rows of all runs together, with the records
+of all inputs of all runs in a single * row.
+
+Optionally specify -map-out (or -m) to write a map file that can be used to
+reconstruct the full identity record from the identity elements. The map is
+the one artifact that re-links the elements, so it has its own destination: a
+file path, an s3://bucket/prefix/name URL, or - for the standard output. A
+relative map file path is resolved against the current working directory, not
+the output directory. The map is refused in the same directory or s3 prefix as
+the element files unless -allow-map-with-elements is given. A map written to
+the standard output is written before the element files are renamed into
+place and cannot be withdrawn if they then fail.
+
+Anyone who can read the full map can re-link every element. With -split-map
+one narrow map per element type is written instead, named after the map
+destination with the element type appended (map_ssn.psv, map_email.psv, ...),
+so that access to each can be granted independently.
+
+Source record ids often encode account numbers. With -crosswalk-out each
+record id in the map is replaced by a surrogate id of the -id scheme, and the
+crosswalk from record ids to surrogate ids is written to its own destination,
+which takes the same forms as -map-out, so that the map alone reveals no
+source record ids. Keep the crosswalk apart from the map.
+
+Element ids are random version 4 UUIDs unless another scheme is selected with
+-id: uuid7 and ulid are time ordered and index well, while base32 and base58
+are shorter encodings of 128 random bits. With -random-time the timestamp of
+time ordered ids is drawn at random from the current day to hide when the
+elements were generated. Even so they reveal the date, so that the elements
+added by each run with -append could be told apart: -append refuses uuid7 and
+ulid.
+
+For testing and reproducing problems, -seed makes the output entirely
+determined by the given seed. Seeded output is INSECURE: anyone who knows the
+seed can re-link the identity elements. Time ordered ids use a fixed time
+instead of the current time. With -append the record ids already in the map
+are mixed into the seed, so that each run generates new ids and its output is
+determined by the seed and the output of the prior runs. Seeded runs refuse
+to write to a directory or s3 prefix containing a ` + ProductionMarker + `
+file unless -force-seed is also given.
+
+Run "idfactor gen -h" for help on generating synthetic input,
+"idfactor reconstruct -h" for help on rebuilding records from maps, and
+"idfactor erase -h" for help on erasing records from a delivery.
 
 `
 	fmt.Fprint(os.Stderr, str)
@@ -133,78 +761,541 @@ full identity record from the identity elements.
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gen":
+			genMain(os.Args[2:])
+			return
+		case "reconstruct":
+			reconstructMain(os.Args[2:])
+			return
+		case "erase":
+			eraseMain(os.Args[2:])
+			return
+		}
+	}
+
 	var (
 		delim           string
 		mapfile         string
 		dir             string
 		fieldsPerRecord int
 		isCompromised   bool
-		factor          func([][]string) ([][]string, error)
+		scheme          string
+		randomTime      bool
+		seed            int64
+		seeded          bool
+		forceSeed       bool
+		allowMap        bool
+		splitMap        bool
+		crosswalkOut    string
+		provenanceOut   string
+		appendMode      bool
+		byBreach        bool
+		breachesFile    string
+		dupPolicy       string
+		dupReport       string
+		tolerant        bool
+		rejects         string
+		encoding        string
+		inQuote         string
+		inEscape        string
+		outDelim        string
+		quote           string
+		escape          string
+		eol             string
+		timeout         time.Duration
+		force           bool
+		permStr         string
+		sse             string
+		kmsKey          string
+		elements        []idfactor.Element
 	)
 
-	flag.StringVar(&delim, "d", "|", "field `delimiter` for the input file")
-	flag.StringVar(&mapfile, "m", "", "write an identity map to the named `file`")
+	flag.StringVar(&delim, "d", "|", "field `delimiter` for the input file, of one or more characters; \\t for tab")
+	flag.StringVar(&inQuote, "input-quote", `"`, "quote `character` for the input file, or none")
+	flag.StringVar(&inEscape, "input-escape", "", "escape `character` for the input file")
+	flag.StringVar(&mapfile, "map-out", "", "write an identity map to `destination`: a file, s3://bucket/prefix/name, or - for the standard output")
+	flag.StringVar(&mapfile, "m", "", "same as -map-out")
+	flag.BoolVar(&splitMap, "split-map", false, "write one identity map per element type")
+	flag.StringVar(&crosswalkOut, "crosswalk-out", "", "replace record ids in the map with surrogate ids and write the crosswalk to `destination`")
+	flag.StringVar(&provenanceOut, "provenance-out", "", "write the input file of each record to `destination`")
+	flag.StringVar(&dupPolicy, "duplicates", "fail", "`policy` for repeated record ids: "+strings.Join(idfactor.DuplicatePolicies, ", "))
+	flag.StringVar(&dupReport, "duplicates-report", "", "write a report of repeated record ids to the named `file`")
+	flag.StringVar(&outDelim, "od", "|", "field `delimiter` for the output files; \\t for tab")
+	flag.StringVar(&quote, "quote", "minimal", "output quote `policy`: "+strings.Join(idfactor.QuotePolicies, ", "))
+	flag.StringVar(&escape, "escape", `\`, "escape `character` for -quote never")
+	flag.StringVar(&eol, "eol", "lf", "output line `terminator`: lf or crlf")
+	flag.StringVar(&encoding, "encoding", input.Auto, "character `encoding` of the input file: "+strings.Join(input.Encodings, ", "))
+	flag.BoolVar(&tolerant, "tolerant", false, "skip malformed input rows instead of stopping")
+	flag.StringVar(&rejects, "rejects", "", "with -tolerant, write malformed input rows to the named `file`")
+	flag.BoolVar(&appendMode, "append", false, "add the output to that of earlier runs with the same destinations")
+	flag.BoolVar(&allowMap, "allow-map-with-elements", false, "allow the map and crosswalk in the same directory as the element files")
 	flag.StringVar(&dir, "o", "", "write the identity elements to the named `directory`")
 	flag.BoolVar(&isCompromised, "c", false, "use compromised entity input format")
+	flag.StringVar(&breachesFile, "breaches", "", "with -c, read breach metadata from the named `file`")
+	flag.BoolVar(&byBreach, "by-breach", false, "with -c, write the output of each breach to its own subdirectory")
+	flag.StringVar(&scheme, "id", "uuid4", "element id `scheme`: "+strings.Join(ids.Schemes, ", "))
+	flag.BoolVar(&randomTime, "random-time", false, "randomize the timestamp of time ordered element ids within the current day")
+	flag.Int64Var(&seed, "seed", 0, "INSECURE: make output deterministic using the given `seed`")
+	flag.BoolVar(&forceSeed, "force-seed", false, "allow -seed output in a production directory")
+	flag.DurationVar(&timeout, "timeout", 0, "stop factoring after the given `duration`")
+	flag.BoolVar(&force, "force", false, "overwrite existing output files")
+	flag.StringVar(&permStr, "perm", "0600", "octal permission `mode` of output files")
+	flag.StringVar(&sse, "sse", "", "server side encryption `algorithm` for s3 output: AES256 or aws:kms")
+	flag.StringVar(&kmsKey, "sse-kms-key", "", "KMS key `id` for aws:kms server side encryption")
 	flag.Usage = usage
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seeded = true
+		}
+	})
 
 	// check at-risk or compromised mode
 	if isCompromised {
 		fieldsPerRecord = compromised.RecordLength
-		factor = CompromisedIDFactoring
+		elements = compromised.Elements
 	} else {
 		fieldsPerRecord = atrisk.RecordLength
-		factor = AtRiskIDFactoring
+		elements = atrisk.Elements
+	}
+
+	// duplicate record id policy
+	policy, err := idfactor.ParseDuplicatePolicy(dupPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// output format
+	format, err := parseFormat(outDelim, quote, escape, eol)
+	if err != nil {
+		log.Fatalf("bad output format: %s", err)
+	}
+
+	// element id scheme
+	var now time.Time
+	if seeded {
+		now = seedTime
+	}
+	gen, err := ids.Parse(scheme, randomTime, now)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// output file permissions
+	perm, err := strconv.ParseUint(permStr, 8, 32)
+	if err != nil || perm > 0777 {
+		log.Fatalf(`invalid permission mode "%s"`, permStr)
+	}
+
+	// output destination
+	switch sse {
+	case "", "AES256", "aws:kms":
+	default:
+		log.Fatalf(`invalid server side encryption algorithm "%s"`, sse)
+	}
+	outFS, err := outputFS(dir, os.FileMode(perm), sse, kmsKey)
+	if err != nil {
+		log.Fatalf("error opening output directory: %s", err)
+	}
+
+	// map and crosswalk destinations
+	if splitMap && (mapfile == "" || mapfile == "-") {
+		log.Fatal("-split-map requires a -map-out file destination")
+	}
+	if crosswalkOut != "" && mapfile == "" {
+		log.Fatal("-crosswalk-out requires -map-out")
+	}
+	stdout := 0
+	for _, dest := range []string{mapfile, crosswalkOut, provenanceOut} {
+		if dest == "-" {
+			stdout++
+		}
+	}
+	if stdout > 1 {
+		log.Fatal("only one of the map, the crosswalk and the provenance can be written to the standard output")
+	}
+	if appendMode && (mapfile == "" || stdout > 0) {
+		log.Fatal("-append requires a -map-out file destination and cannot write to the standard output")
+	}
+	if appendMode && ids.TimeOrdered(scheme) {
+		log.Fatalf("-append cannot be used with -id %s, whose ids would reveal the date of each run", scheme)
+	}
+	if breachesFile != "" && !isCompromised {
+		log.Fatal("-breaches requires -c")
+	}
+	if byBreach && (!isCompromised || appendMode || stdout > 0) {
+		log.Fatal("-by-breach requires -c and cannot be used with -append or write to the standard output")
+	}
+	var mapDest, crosswalkDest, provenanceDest fileDest
+	if mapfile != "" {
+		if mapDest, err = openDest(mapfile, dir, allowMap, os.FileMode(perm), sse, kmsKey); err != nil {
+			log.Fatalf("error opening map destination: %s", err)
+		}
+	}
+	if crosswalkOut != "" {
+		if crosswalkDest, err = openDest(crosswalkOut, dir, allowMap, os.FileMode(perm), sse, kmsKey); err != nil {
+			log.Fatalf("error opening crosswalk destination: %s", err)
+		}
+		if crosswalkDest.fs != nil && mapDest.fs != nil && sameDest(crosswalkDest.dir, mapDest.dir) {
+			log.Printf("WARNING: the crosswalk and the map are written to the same directory; protect them separately")
+		}
+	}
+	if provenanceOut != "" {
+		if provenanceDest, err = openDest(provenanceOut, dir, allowMap, os.FileMode(perm), sse, kmsKey); err != nil {
+			log.Fatalf("error opening provenance destination: %s", err)
+		}
+	}
+
+	// refuse deterministic output in production directories
+	if seeded {
+		log.Printf("WARNING: -seed output is deterministic and INSECURE; do not deliver it")
+		dests := map[string]idfactor.FS{dir: outFS}
+		for _, d := range []fileDest{mapDest, crosswalkDest, provenanceDest} {
+			if d.fs != nil {
+				dests[d.dir] = d.fs
+			}
+		}
+		for d, fs := range dests {
+			if isProduction(fs) && !forceSeed {
+				log.Fatalf(`refusing to write seeded output to production directory "%s" (use -force-seed to override)`, d)
+			}
+		}
+	}
+
+	if rejects != "" && !tolerant {
+		log.Fatal("-rejects requires -tolerant")
+	}
+
+	// check the input delimiter, quote and escape characters
+	var dialect input.Dialect
+	dialect.Delimiter = delim
+	if delim == `\t` {
+		dialect.Delimiter = "\t"
+	}
+	if !utf8.ValidString(dialect.Delimiter) {
+		log.Fatal("bad delimiter: invalid UTF-8")
+	}
+	if inQuote != "none" {
+		if dialect.Quote, err = parseRune(inQuote); err != nil {
+			log.Fatalf("bad input quote character: %s", err)
+		}
+	}
+	if inEscape != "" {
+		if dialect.Escape, err = parseRune(inEscape); err != nil {
+			log.Fatalf("bad input escape character: %s", err)
+		}
+	}
+	if err := dialect.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	opts := readOptions{dialect: dialect, encoding: encoding, fieldsPerRecord: fieldsPerRecord, tolerant: tolerant}
+
+	// expand input paths, directories and globs
+	inputs, err := expandInputs(flag.Args())
+	if err != nil {
+		log.Fatalf("error opening input file: %s", err)
 	}
 
-	// check for single char delimiter
-	if len(delim) != 1 {
-		log.Fatal("delimiter must be exactly one character")
+	// malformed rows are quarantined in the rejects file
+	var rejectsReport bytes.Buffer
+	var rejectsOut input.RowWriter
+	if rejects != "" {
+		rejectsOut = format.NewWriter(&rejectsReport)
+	}
+	rejectWriter, err := input.NewRejectWriter(rejectsOut)
+	if err != nil {
+		log.Fatalf("error writing rejects file: %s", err)
 	}
 
-	// read input from stdin or file
+	// read all records of all inputs into one run
+	opts.reject = rejectWriter.Reject
 	var (
-		in  io.ReadCloser
-		err error
+		header  []string
+		records [][]string
+		sources []string
+		lines   []int
 	)
-	if flag.Arg(0) == "" {
-		// read from stdin if no input file supplied
-		in = os.Stdin
-	} else {
-		// otherwise open the file for reading
-		in, err = os.Open(flag.Arg(0))
+	names := inputNames(inputs)
+	for i, name := range inputs {
+		h, recs, recLines, err := readInput(name, opts, len(inputs) > 1)
+		if err != nil {
+			log.Fatalf("error reading file %s: %s", name, err)
+		}
+		if header == nil {
+			header = h
+		} else if h != nil && !slices.Equal(h, header) {
+			log.Fatalf("error reading file %s: header differs from %s", name, inputs[0])
+		}
+		records = append(records, recs...)
+		lines = append(lines, recLines...)
+		for range recs {
+			sources = append(sources, names[i])
+		}
+	}
+	if err := rejectWriter.Flush(); err != nil {
+		log.Fatalf("error writing rejects file: %s", err)
+	}
+
+	// check the breach ids of the records against the breach metadata
+	var breaches map[string]compromised.Breach
+	if breachesFile != "" {
+		breaches, err = readBreaches(breachesFile, opts)
 		if err != nil {
-			log.Fatalf("error opening input file: %s", err)
+			log.Fatalf("error reading breach metadata %s: %s", breachesFile, err)
+		}
+		breachIDs, _ := compromised.Partition(records)
+		var unknown []string
+		for _, id := range breachIDs {
+			if _, ok := breaches[id]; !ok {
+				unknown = append(unknown, id)
+			}
+		}
+		if len(unknown) > 0 {
+			for _, id := range unknown {
+				log.Printf("unknown breach id %s", id)
+			}
+			log.Fatalf("error reading file: %d breach ids are not in the breach metadata", len(unknown))
+		}
+		if n := len(breaches) - len(breachIDs); n > 0 {
+			log.Printf("%d breaches in the breach metadata have no records", n)
 		}
 	}
 
-	// read all records
-	reader := csv.NewReader(in)
-	reader.Comma = rune(delim[0])
-	reader.FieldsPerRecord = fieldsPerRecord
-	if _, err = reader.Read(); err != nil {
-		log.Fatalf("error reading file : %s", err)
+	// the record ids and element ids of prior runs
+	var prior *idfactor.IdentityMap
+	var priorRecordIDs []string
+	if appendMode {
+		var crosswalk *fileDest
+		if crosswalkOut != "" {
+			crosswalk = &crosswalkDest
+		}
+		prior, priorRecordIDs, err = readPrior(mapDest, splitMap, crosswalk, elements, format)
+		if err != nil {
+			log.Fatalf("error reading the output of prior runs: %s", err)
+		}
+		if seeded {
+			seed = appendSeed(seed, prior)
+		}
+	}
+
+	// resolve duplicate record ids, including those of prior runs
+	records, collisions, err := idfactor.ResolveDuplicatesWithPrior(records, priorRecordIDs, policy)
+	for i, c := range collisions {
+		collisions[i].File, collisions[i].Line = sources[c.Record-1], lines[c.Record-1]
+	}
+	// the report is written with the output, or on its own if the
+	// duplicates stop the run since it then explains why
+	var collisionReport bytes.Buffer
+	if len(collisions) > 0 {
+		log.Printf("%d records share a record id", len(collisions))
+		if dupReport != "" {
+			if err := (&idfactor.Config{Format: format}).WriteCollisionsToWriter(collisions, &collisionReport); err != nil {
+				log.Fatalf("error writing duplicates report: %s", err)
+			}
+		}
 	}
-	records, err := reader.ReadAll()
 	if err != nil {
-		log.Fatalf("error reading file: %s", err)
+		if collisionReport.Len() > 0 {
+			reports := &idfactor.Transaction{Overwrite: true}
+			if err := stageReport(reports, dupReport, collisionReport.Bytes()); err != nil {
+				reports.Rollback()
+				log.Fatalf("error writing duplicates report: %s", err)
+			}
+			if err := reports.Commit(); err != nil {
+				log.Fatalf("error writing duplicates report: %s", err)
+			}
+		}
+		log.Fatalf("error reading file: %s (use -duplicates to resolve them)", err)
+	}
+	sources = resolveSources(sources, collisions)
+
+	// write output
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	if err := in.Close(); err != nil {
-		log.Fatalf("error closing file: %s", err)
+	tx := &idfactor.Transaction{Overwrite: force || appendMode}
+	config := idfactor.Config{
+		IDs:    gen,
+		FS:     outFS,
+		Tx:     tx,
+		Format: format,
+		Append: appendMode,
+	}
+	if seeded {
+		// used to shuffle appended files
+		config.Rand = csprng.NewInsecureStream(seed, "append")
+	}
+	if len(records) == 0 {
+		log.Printf("no records in input; writing empty output")
+	}
+	// the output of each breach goes to its own subdirectory, while empty
+	// input, which has no breaches, still gets a manifest and a map
+	parts := []part{newPart("", records, sources)}
+	partitioned := byBreach && len(records) > 0
+	if partitioned {
+		parts = partitionByBreach(records, sources)
+		for _, p := range parts {
+			if err := compromised.CheckBreachDir(p.dir); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	var breachStats [][]string
+	for _, p := range parts {
+		idmap, err := IDFactoring(ctx, p.records, elements, config, seeded, seed, p.dir)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("error factoring ids: %s", err)
+		}
+		// the manifest is delivered with the elements, so the inputs are
+		// named without revealing local paths
+		manifestInputs := make([]idfactor.Input, len(inputs))
+		for i, name := range names {
+			manifestInputs[i] = idfactor.Input{Name: name, Records: p.inputs[name]}
+		}
+		manifest := idfactor.NewManifest(manifestInputs, idmap, elements, func(e idfactor.Element) string {
+			return fileNames[e.Name]
+		})
+		// in append mode the manifest counts all runs together, so that it
+		// does not reveal how many records each run added
+		manifestConfig := config
+		if appendMode {
+			var earlier idfactor.Manifest
+			err := readPriorFile(outFS, p.name(ManifestFile), func(r io.Reader) (err error) {
+				earlier, err = idfactor.ReadManifest(r, format)
+				return err
+			})
+			if err != nil {
+				tx.Rollback()
+				log.Fatalf("error reading the output of prior runs: %s", err)
+			}
+			manifest = idfactor.MergeManifests(earlier, manifest)
+			manifestConfig.Append = false
+		}
+		if err := manifestConfig.WriteManifestToFileContext(ctx, manifest, p.name(ManifestFile)); err != nil {
+			tx.Rollback()
+			log.Fatalf("error writing manifest: %s", err)
+		}
+		if breaches != nil {
+			if err := config.WriteRowsToFileContext(ctx, compromised.BreachHeader, breachRows(p.records, breaches), p.name(BreachesFile)); err != nil {
+				tx.Rollback()
+				log.Fatalf("error writing breach metadata: %s", err)
+			}
+		}
+		if partitioned {
+			breachStats = append(breachStats, breachStatsRow(p, manifest))
+			log.Printf("breach %s: %d records", p.dir, len(p.records))
+		}
+		provenance := idfactor.Provenance(p.records, p.sources)
+		if crosswalkOut != "" {
+			crosswalkConfig := config
+			crosswalkConfig.FS = crosswalkDest.fs
+			if seeded {
+				crosswalkConfig.Rand = csprng.NewInsecureStream(seed, path.Join(p.dir, "record_id"))
+			}
+			var crosswalk [][]string
+			idmap, crosswalk = crosswalkConfig.Pseudonymize(idmap)
+			provenance = idfactor.PseudonymizeProvenance(provenance, crosswalk)
+			if crosswalkDest.fs == nil {
+				err = crosswalkConfig.WriteCrosswalkToWriterContext(ctx, crosswalk, os.Stdout)
+			} else {
+				err = crosswalkConfig.WriteCrosswalkToFileContext(ctx, crosswalk, p.name(crosswalkDest.name))
+			}
+			if err != nil {
+				tx.Rollback()
+				log.Fatalf("error writing crosswalk: %s", err)
+			}
+		}
+		if prior != nil {
+			if id, ok := prior.Reused(idmap); ok {
+				tx.Rollback()
+				log.Fatalf("error factoring ids: id %s was already used by a prior run", id)
+			}
+		}
+		mapConfig := config
+		mapConfig.FS = mapDest.fs
+		switch {
+		case mapfile == "-":
+			err = mapConfig.WriteMapToWriterContext(ctx, idmap, os.Stdout)
+		case splitMap:
+			err = mapConfig.WriteElementMapsContext(ctx, idmap, elements, func(e idfactor.Element) string {
+				return elementMapName(p.name(mapDest.name), e)
+			})
+		case mapfile != "":
+			err = mapConfig.WriteMapToFileContext(ctx, idmap, p.name(mapDest.name))
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("error writing map: %s", err)
+		}
+		if provenanceOut != "" {
+			provenanceConfig := config
+			provenanceConfig.FS = provenanceDest.fs
+			if provenanceDest.fs == nil {
+				err = provenanceConfig.WriteProvenanceToWriterContext(ctx, provenance, os.Stdout)
+			} else {
+				err = provenanceConfig.WriteProvenanceToFileContext(ctx, provenance, p.name(provenanceDest.name))
+			}
+			if err != nil {
+				tx.Rollback()
+				log.Fatalf("error writing provenance: %s", err)
+			}
+		}
+	}
+	if byBreach {
+		if err := config.WriteRowsToFileContext(ctx, breachStatsHeader(elements), breachStats, BreachStatsFile); err != nil {
+			tx.Rollback()
+			log.Fatalf("error writing breach statistics: %s", err)
+		}
+		if breaches != nil {
+			if err := config.WriteRowsToFileContext(ctx, compromised.BreachHeader, breachRows(records, breaches), BreachesFile); err != nil {
+				tx.Rollback()
+				log.Fatalf("error writing breach metadata: %s", err)
+			}
+		}
 	}
 
-	// change to output directory and write output
-	if dir != "" {
-		if err := os.Chdir(dir); err != nil {
-			log.Fatalf(`error setting working directory to "%s":`, err)
+	// reports of the input are committed only once the output is, so that a
+	// failed run leaves none behind
+	reports := &idfactor.Transaction{Overwrite: true}
+	if rejects != "" {
+		if err := stageReport(reports, rejects, rejectsReport.Bytes()); err != nil {
+			tx.Rollback()
+			reports.Rollback()
+			log.Fatalf("error writing rejects file: %s", err)
 		}
 	}
-	ids, err := factor(records)
-	if err != nil {
-		log.Fatalf("error factoring ids: %s", err)
+	if collisionReport.Len() > 0 {
+		if err := stageReport(reports, dupReport, collisionReport.Bytes()); err != nil {
+			tx.Rollback()
+			reports.Rollback()
+			log.Fatalf("error writing duplicates report: %s", err)
+		}
 	}
-	if mapfile != "" {
-		idfactor.WriteMapToFile(ids, mapfile)
+	if err := tx.Commit(); err != nil {
+		reports.Rollback()
+		log.Fatalf("error writing output: %s", err)
+	}
+	if err := reports.Commit(); err != nil {
+		log.Fatalf("error writing reports: %s", err)
+	}
+
+	// summarize rejected rows
+	if rejectWriter.Total > 0 {
+		log.Printf("%d malformed rows rejected:", rejectWriter.Total)
+		reasons := make([]string, 0, len(rejectWriter.Counts))
+		for reason := range rejectWriter.Counts {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			log.Printf("  %d: %s", rejectWriter.Counts[reason], reason)
+		}
 	}
 }
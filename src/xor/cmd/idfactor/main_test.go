@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"xor/lib/idfactor"
+	"xor/lib/input"
+)
+
+// TestMain runs the command instead of the tests when the test binary is run
+// by run.
+func TestMain(m *testing.M) {
+	if os.Getenv("IDFACTOR_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// run runs the command with the given arguments in dir and returns its
+// standard error.
+func run(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "IDFACTOR_TEST_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stderr.String(), err
+}
+
+// mustRun runs the command like run and fails the test if it fails.
+func mustRun(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	if stderr, err := run(t, dir, args...); err != nil {
+		t.Fatalf("idfactor %s: %s\n%s", strings.Join(args, " "), err, stderr)
+	}
+}
+
+// readRows reads the pipe delimited rows of the named file in dir, header
+// included.
+func readRows(t *testing.T, dir, name string) [][]string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		rows = append(rows, strings.Split(line, "|"))
+	}
+	return rows
+}
+
+// writeFile writes a file named name in dir.
+func writeFile(t *testing.T, dir, name, text string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInputNames(t *testing.T) {
+	for _, tc := range []struct {
+		inputs, want []string
+	}{
+		{[]string{"-"}, []string{"-"}},
+		{[]string{"/data/in.psv"}, []string{"in.psv"}},
+		{[]string{"a/part-1.psv", "b/part-1.psv", "b/part-2.psv"}, []string{"a/part-1.psv", "b/part-1.psv", "part-2.psv"}},
+		{[]string{"x/a/in.psv", "y/a/in.psv", "in.psv"}, []string{"x/a/in.psv", "y/a/in.psv", "in.psv"}},
+	} {
+		if got := inputNames(tc.inputs); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("inputNames(%q) = %q, want %q", tc.inputs, got, tc.want)
+		}
+	}
+}
+
+func TestSeededAppend(t *testing.T) {
+	var outputs [2]string
+	for i := range outputs {
+		dir := t.TempDir()
+		mustRun(t, dir, "gen", "-n", "4", "-seed", "1", "a.psv")
+		mustRun(t, dir, "gen", "-n", "4", "-seed", "2", "b.psv")
+		mustRun(t, dir, "-seed", "1", "-append", "-o", "out", "-m", "map.psv", "a.psv")
+		mustRun(t, dir, "-seed", "1", "-append", "-duplicates", "suffix", "-o", "out", "-m", "map.psv", "b.psv")
+		for _, name := range []string{"map.psv", "out/ssn_elements.psv"} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs[i] += string(data)
+		}
+	}
+	if outputs[0] != outputs[1] {
+		t.Error("seeded appending runs are not reproducible")
+	}
+	if n := strings.Count(outputs[0], "\n"); n != 2*(1+8) {
+		t.Errorf("got %d lines, want %d", n, 2*(1+8))
+	}
+}
+
+func TestReportFormat(t *testing.T) {
+	dir := t.TempDir()
+	mustRun(t, dir, "gen", "-n", "2", "a.psv")
+	data, err := os.ReadFile(filepath.Join(dir, "a.psv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	// a duplicate record and a malformed row
+	writeFile(t, dir, "a.psv", string(data)+lines[1]+"x|y\n")
+	mustRun(t, dir, "-tolerant", "-rejects", "rejects.csv", "-duplicates", "suffix", "-duplicates-report", "dups.csv",
+		"-od", ",", "-eol", "crlf", "-o", "out", "-m", "map.csv", "a.psv")
+	for name, header := range map[string][]string{"rejects.csv": input.RejectHeader, "dups.csv": idfactor.CollisionHeader} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.Join(header, ",") + "\r\n"; !strings.HasPrefix(string(data), want) {
+			t.Errorf("%s starts %q, want %q", name, data, want)
+		}
+	}
+}
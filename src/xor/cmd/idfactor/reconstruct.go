@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"xor/lib/idfactor"
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+)
+
+var reconstructUsage = func() {
+	str := `usage: idfactor reconstruct [-c] [-e directory] [-x crosswalk] [-ed delimiter]
+                             [-quote policy [-escape char]] [-d delimiter] [-o file] map...
+
+Rebuild full identity records from identity maps and identity element files.
+
+Each map is either a full identity map or a narrow map of a single element
+type written with -split-map. Any subset of the maps may be given: only the
+element files of the element types they cover are read, and the fields of
+other element types are left empty. Element files are read from the current
+working directory unless another directory is specified with -e. If -c is
+specified then compromised entity input format is written. If the maps hold
+surrogate record ids then -x names the crosswalk that restores the source
+record ids. If the files were written with another -od delimiter then -ed
+gives it, and if they were written with -quote never then so must -quote be
+given, with the -escape character they were written with.
+
+Records are written in input format to the named file, which must not exist
+and is readable only by its owner, or to the standard output.
+
+`
+	fmt.Fprint(os.Stderr, str)
+	flag.CommandLine.PrintDefaults()
+}
+
+func reconstructMain(args []string) {
+	var (
+		delim         string
+		dir           string
+		out           string
+		crosswalk     string
+		elementDelim  string
+		quote         string
+		escape        string
+		isCompromised bool
+	)
+
+	flag.CommandLine = flag.NewFlagSet("idfactor reconstruct", flag.ExitOnError)
+	flag.StringVar(&delim, "d", "|", "field `delimiter` for the output file; \\t for tab")
+	flag.StringVar(&elementDelim, "ed", "|", "field `delimiter` of the maps, crosswalk and element files; \\t for tab")
+	flag.StringVar(&quote, "quote", "minimal", "quote `policy` the maps, crosswalk and element files were written with: "+strings.Join(idfactor.QuotePolicies, ", "))
+	flag.StringVar(&escape, "escape", `\`, "escape `character` the files were written with under -quote never")
+	flag.StringVar(&dir, "e", "", "read the identity elements from the named `directory`")
+	flag.StringVar(&crosswalk, "x", "", "restore source record ids using the named crosswalk `file`")
+	flag.StringVar(&out, "o", "", "write the records to the named `file`")
+	flag.BoolVar(&isCompromised, "c", false, "use compromised entity input format")
+	flag.Usage = reconstructUsage
+	flag.CommandLine.Parse(args)
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	outComma, err := parseRune(delim)
+	if err != nil {
+		log.Fatalf("bad delimiter: %s", err)
+	}
+	header, elements := atrisk.Header, atrisk.Elements
+	if isCompromised {
+		header, elements = compromised.Header, compromised.Elements
+	}
+
+	// merge the maps
+	format, err := parseFormat(elementDelim, quote, escape, "lf")
+	if err != nil {
+		log.Fatalf("bad element format: %s", err)
+	}
+	m := idfactor.NewIdentityMap()
+	m.Format = format
+	for _, name := range flag.Args() {
+		if err := readFile(name, m.Read); err != nil {
+			log.Fatalf("error reading map %s: %s", name, err)
+		}
+	}
+
+	// read the element files covered by the maps
+	files := make(map[string][][]string)
+	for _, e := range elements {
+		if !m.Has(e) {
+			continue
+		}
+		name := filepath.Join(dir, fileNames[e.Name])
+		err := readFile(name, func(r io.Reader) (err error) {
+			files[e.Name], err = format.ReadAll(r)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("error reading elements %s: %s", name, err)
+		}
+	}
+	recs, err := m.Reconstruct(header, elements, files)
+	if err != nil {
+		log.Fatalf("error reconstructing records: %s", err)
+	}
+
+	// restore source record ids
+	if crosswalk != "" {
+		var recordIDs map[string]string
+		err := readFile(crosswalk, func(r io.Reader) (err error) {
+			recordIDs, err = idfactor.ReadCrosswalk(r, format)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("error reading crosswalk %s: %s", crosswalk, err)
+		}
+		for _, rec := range recs {
+			recordID, ok := recordIDs[rec[0]]
+			if !ok {
+				log.Fatalf("surrogate id %s is not in the crosswalk", rec[0])
+			}
+			rec[0] = recordID
+		}
+	}
+
+	// write to stdout or file
+	var file io.WriteCloser = os.Stdout
+	if out != "" {
+		file, err = os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, idfactor.DefaultPerm)
+		if err != nil {
+			log.Fatalf("error creating output file: %s", err)
+		}
+	}
+	w := bufio.NewWriter(file)
+	writer := csv.NewWriter(w)
+	writer.Comma = outComma
+	writer.Write(header)
+	writer.WriteAll(recs)
+	if err := writer.Error(); err != nil {
+		log.Fatalf("error writing records: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("error writing records: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalf("error closing file: %s", err)
+	}
+}
+
+// readFile opens the named file and reads it with read
+func readFile(name string, read func(r io.Reader) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return read(file)
+}
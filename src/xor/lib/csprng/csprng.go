@@ -0,0 +1,188 @@
+// Package csprng provides fast access to cryptographically secure random
+// numbers. Rand reads random bytes from crypto/rand in large blocks and hands
+// them out from a buffer, avoiding a system call per request. Stream expands a
+// key read from crypto/rand with the ChaCha8 stream cipher, avoiding system
+// calls altogether after it is created.
+package csprng
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/bits"
+	mrand "math/rand/v2"
+	"sync"
+)
+
+// Source is a source of random numbers. Rand and Stream implement Source. A
+// Source need not be safe for concurrent use.
+type Source interface {
+	// Read fills p with random bytes. It always returns len(p) and a nil
+	// error.
+	Read(p []byte) (int, error)
+	// Uint64 returns a uniformly distributed random 64-bit value.
+	Uint64() uint64
+}
+
+// size of the buffer of random bytes held by a Rand
+const bufferSize = 4096
+
+// Rand is a buffered source of cryptographically secure random numbers. A Rand
+// is not safe for concurrent use; the package level functions are.
+type Rand struct {
+	buf [bufferSize]byte
+	off int
+}
+
+// New returns a new Rand.
+func New() *Rand {
+	return &Rand{off: bufferSize}
+}
+
+// fill refills the buffer from crypto/rand or halts execution
+func (r *Rand) fill() {
+	if _, err := rand.Read(r.buf[:]); err != nil {
+		log.Fatalf("csprng: failed reading random bytes: %s", err)
+	}
+	r.off = 0
+}
+
+// Read fills p with random bytes. It always returns len(p) and a nil error.
+func (r *Rand) Read(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if r.off == bufferSize {
+			r.fill()
+		}
+		m := copy(p, r.buf[r.off:])
+		r.off += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Uint64 returns a uniformly distributed random 64-bit value.
+func (r *Rand) Uint64() uint64 {
+	if bufferSize-r.off < 8 {
+		r.fill()
+	}
+	v := binary.LittleEndian.Uint64(r.buf[r.off:])
+	r.off += 8
+	return v
+}
+
+// Uint64n returns a uniformly distributed random value in [0,n). It halts
+// execution if n is zero.
+func (r *Rand) Uint64n(n uint64) uint64 {
+	return Bounded(r, n)
+}
+
+//------------------------------------------------------------------------------
+// ChaCha8 keystream generator
+//------------------------------------------------------------------------------
+
+// Stream is a cryptographically secure random number generator that expands a
+// 256-bit key read from crypto/rand with the ChaCha8 stream cipher. A Stream
+// is not safe for concurrent use.
+type Stream struct {
+	c *mrand.ChaCha8
+}
+
+// NewStream returns a new Stream keyed from crypto/rand.
+func NewStream() *Stream {
+	var key [32]byte
+	Read(key[:])
+	return &Stream{c: mrand.NewChaCha8(key)}
+}
+
+// Read fills p with random bytes. It always returns len(p) and a nil error.
+func (s *Stream) Read(p []byte) (int, error) {
+	return s.c.Read(p)
+}
+
+// Uint64 returns a uniformly distributed random 64-bit value.
+func (s *Stream) Uint64() uint64 {
+	return s.c.Uint64()
+}
+
+// Uint64n returns a uniformly distributed random value in [0,n). It halts
+// execution if n is zero.
+func (s *Stream) Uint64n(n uint64) uint64 {
+	return Bounded(s, n)
+}
+
+// NewInsecureStream returns a Stream whose output is entirely determined by
+// the given seed and label. Streams with the same seed and different labels
+// produce unrelated output.
+//
+// INSECURE: anyone who knows or guesses the seed can reproduce the output.
+// Use it only to make test runs reproducible, never for data that is
+// delivered.
+func NewInsecureStream(seed int64, label string) *Stream {
+	key := sha256.Sum256([]byte(fmt.Sprintf("idfactor insecure stream %d %s", seed, label)))
+	return &Stream{c: mrand.NewChaCha8(key)}
+}
+
+// Bounded returns a uniformly distributed random value in [0,n) drawn from
+// src. It halts execution if n is zero.
+//
+// It uses Lemire's multiply and shift method, which only needs a division in
+// the rare case that a sample must be rejected to avoid bias.
+func Bounded(src Source, n uint64) uint64 {
+	if n == 0 {
+		log.Fatal("csprng: n must be positive in call to Uint64n")
+	}
+	hi, lo := bits.Mul64(src.Uint64(), n)
+	if lo < n {
+		thresh := -n % n
+		for lo < thresh {
+			hi, lo = bits.Mul64(src.Uint64(), n)
+		}
+	}
+	return hi
+}
+
+//------------------------------------------------------------------------------
+// Package level functions share a pool of Rands and are safe for concurrent
+// use.
+//------------------------------------------------------------------------------
+
+var pool = sync.Pool{New: func() interface{} { return New() }}
+
+// Get returns a Rand for exclusive use by the caller until it is returned
+// with Put.
+func Get() *Rand {
+	return pool.Get().(*Rand)
+}
+
+// Put returns a Rand obtained from Get to the pool.
+func Put(r *Rand) {
+	pool.Put(r)
+}
+
+// Read fills p with random bytes. It always returns len(p) and a nil error.
+func Read(p []byte) (int, error) {
+	r := Get()
+	n, err := r.Read(p)
+	Put(r)
+	return n, err
+}
+
+// Uint64 returns a uniformly distributed random 64-bit value.
+func Uint64() uint64 {
+	r := Get()
+	v := r.Uint64()
+	Put(r)
+	return v
+}
+
+// Uint64n returns a uniformly distributed random value in [0,n). It halts
+// execution if n is zero.
+func Uint64n(n uint64) uint64 {
+	r := Get()
+	v := r.Uint64n(n)
+	Put(r)
+	return v
+}
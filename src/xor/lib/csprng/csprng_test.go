@@ -0,0 +1,125 @@
+package csprng
+
+import (
+	"sync"
+	"testing"
+)
+
+// source is satisfied by both Rand and Stream
+type source interface {
+	Uint64n(n uint64) uint64
+}
+
+func TestUint64nRange(t *testing.T) {
+	testUint64nRange(t, New())
+	testUint64nRange(t, NewStream())
+}
+
+func testUint64nRange(t *testing.T, r source) {
+	for _, n := range []uint64{1, 2, 3, 7, 1000, 1<<63 + 1, ^uint64(0)} {
+		for i := 0; i < 1000; i++ {
+			if v := r.Uint64n(n); v >= n {
+				t.Fatalf("Uint64n(%d) = %d", n, v)
+			}
+		}
+	}
+}
+
+func TestUint64nUniform(t *testing.T) {
+	testUint64nUniform(t, New())
+	testUint64nUniform(t, NewStream())
+}
+
+func testUint64nUniform(t *testing.T, r source) {
+	const n, samples = 6, 600000
+	var counts [n]int
+	for i := 0; i < samples; i++ {
+		counts[r.Uint64n(n)]++
+	}
+	// chi-squared with 5 degrees of freedom; 25.7 is the 0.9999 quantile
+	var chi2 float64
+	expected := float64(samples) / n
+	for _, c := range counts {
+		d := float64(c) - expected
+		chi2 += d * d / expected
+	}
+	if chi2 > 25.7 {
+		t.Errorf("counts %v are not uniform (chi2 = %.1f)", counts, chi2)
+	}
+}
+
+func TestReadCrossesBuffer(t *testing.T) {
+	r := New()
+	p := make([]byte, 3*bufferSize+5)
+	if n, err := r.Read(p); n != len(p) || err != nil {
+		t.Fatalf("Read = %d, %v", n, err)
+	}
+	zeros := 0
+	for _, b := range p {
+		if b == 0 {
+			zeros++
+		}
+	}
+	if zeros > len(p)/64 {
+		t.Errorf("%d of %d bytes are zero", zeros, len(p))
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				Uint64n(10)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkUint64(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Uint64()
+	}
+}
+
+func TestStreamsDiffer(t *testing.T) {
+	a, b := NewStream(), NewStream()
+	if a.Uint64() == b.Uint64() && a.Uint64() == b.Uint64() {
+		t.Error("streams produced the same output")
+	}
+}
+
+func TestInsecureStream(t *testing.T) {
+	a, b := NewInsecureStream(1, "ssn"), NewInsecureStream(1, "ssn")
+	for i := 0; i < 100; i++ {
+		if a.Uint64() != b.Uint64() {
+			t.Fatal("streams with equal seeds and labels differ")
+		}
+	}
+	if NewInsecureStream(1, "ssn").Uint64() == NewInsecureStream(1, "phone").Uint64() {
+		t.Error("streams with different labels agree")
+	}
+	if NewInsecureStream(1, "ssn").Uint64() == NewInsecureStream(2, "ssn").Uint64() {
+		t.Error("streams with different seeds agree")
+	}
+}
+
+func BenchmarkStreamUint64n(b *testing.B) {
+	s := NewStream()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Uint64n(uint64(i) + 1)
+	}
+}
+
+func BenchmarkUint64n(b *testing.B) {
+	r := New()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Uint64n(uint64(i) + 1)
+	}
+}
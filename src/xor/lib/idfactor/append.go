@@ -0,0 +1,89 @@
+package idfactor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"xor/lib/shuffle"
+)
+
+//------------------------------------------------------------------------------
+// Incremental output. A run in append mode adds its rows to the output files
+// of earlier runs, so that a regular feed builds up a single delivery.
+//------------------------------------------------------------------------------
+
+// appendTo returns a write function that combines the rows written by write
+// with those of the named existing file in fs, if there is one, and writes
+// them in shuffled order, so that the rows added by a run cannot be told
+// apart from those of earlier runs, or sorted with cmp if it is not nil. The
+// header written by write must match that of the existing file. Rows that are
+// already in the file, such as the metadata of a breach seen before, are not
+// repeated.
+func (c *Config) appendTo(fs FS, name string, cmp func(a, b []string) int, write func(w io.Writer) error) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		r, err := fs.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return write(w)
+		}
+		if err != nil {
+			return fmt.Errorf(`idfactor: error opening "%s" to append to it: %w`, name, err)
+		}
+		existing, err := c.Format.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf(`idfactor: error reading "%s" to append to it: %w`, name, err)
+		}
+		if len(existing) == 0 {
+			return write(w)
+		}
+
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return err
+		}
+		added, err := c.Format.ReadAll(&buf)
+		if err != nil {
+			return fmt.Errorf(`idfactor: error appending to "%s": %w`, name, err)
+		}
+		if len(added) == 0 {
+			return fmt.Errorf(`idfactor: cannot append to "%s": no header written`, name)
+		}
+		if !slices.Equal(added[0], existing[0]) {
+			return fmt.Errorf(`idfactor: cannot append to "%s", which has a different header or format: %q`, name, existing[0])
+		}
+
+		rows := existing[1:]
+		seen := make(map[string]bool, len(rows))
+		for _, row := range rows {
+			seen[rowKey(row)] = true
+		}
+		for _, row := range added[1:] {
+			if !seen[rowKey(row)] {
+				rows = append(rows, row)
+			}
+		}
+		if cmp != nil {
+			slices.SortFunc(rows, cmp)
+			return c.writeRows(context.Background(), w, existing[0], rows, name)
+		}
+		shuffled := make([][]string, len(rows))
+		for n, i := range shuffle.New(c.source()).Shuffle(len(rows)) {
+			shuffled[n] = rows[i]
+		}
+		return c.writeRows(context.Background(), w, existing[0], shuffled, name)
+	}
+}
+
+// rowKey returns a key that is the same for rows with the same fields
+func rowKey(row []string) string {
+	var key bytes.Buffer
+	for _, field := range row {
+		fmt.Fprintf(&key, "%d:%s", len(field), field)
+	}
+	return key.String()
+}
@@ -0,0 +1,112 @@
+package idfactor_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"xor/lib/csprng"
+	"xor/lib/idfactor"
+)
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	write := func(config idfactor.Config, provenance [][]string) error {
+		tx := &idfactor.Transaction{Overwrite: true}
+		config.FS, config.Tx, config.Append = idfactor.DirFS{Dir: dir}, tx, true
+		config.Rand = csprng.NewInsecureStream(1, "append")
+		if err := config.WriteProvenanceToFileContext(ctx, provenance, "provenance.psv"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if err := write(idfactor.Config{}, [][]string{{"r1", "s1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(idfactor.Config{}, [][]string{{"r2", "s2"}, {"r3", "s3"}}); err != nil {
+		t.Fatal(err)
+	}
+	// rows already in the file are not repeated
+	if err := write(idfactor.Config{}, [][]string{{"r1", "s1"}, {"r4", "s4"}}); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "provenance.psv")
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(got), "\n")
+	if lines[0] != "record_id|source\n" {
+		t.Errorf("header = %q", lines[0])
+	}
+	rows := slices.Clone(lines[1 : len(lines)-1])
+	slices.Sort(rows)
+	if want := []string{"r1|s1\n", "r2|s2\n", "r3|s3\n", "r4|s4\n"}; !slices.Equal(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+	// the rows of the earlier runs are shuffled in with the new ones
+	if slices.Equal(lines[1:len(lines)-1], rows) {
+		t.Error("rows were appended in order")
+	}
+
+	// a different format cannot be appended and leaves the file as it was
+	if err := write(idfactor.Config{Format: idfactor.Format{Comma: ','}}, [][]string{{"r5", "s5"}}); err == nil {
+		t.Error("appended in a different format")
+	}
+	checkFile(t, name, string(got))
+}
+
+func TestAppendCrosswalk(t *testing.T) {
+	dir := t.TempDir()
+	config := idfactor.Config{FS: idfactor.DirFS{Dir: dir}, Append: true}
+	for _, crosswalk := range [][][]string{{{"r2", "s2"}, {"r4", "s4"}}, {{"r1", "s1"}, {"r3", "s3"}}} {
+		if err := config.WriteCrosswalkToFileContext(context.Background(), crosswalk, "crosswalk.psv"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the crosswalk stays sorted by record id
+	checkFile(t, filepath.Join(dir, "crosswalk.psv"), "record_id|surrogate_id\nr1|s1\nr2|s2\nr3|s3\nr4|s4\n")
+}
+
+func TestMergeManifests(t *testing.T) {
+	first := idfactor.Manifest{
+		{File: "a.psv", Element: "record", Rows: 3},
+		{File: "b.psv", Element: "record", Rows: 2},
+		{File: "name.psv", Element: "name", Rows: 4},
+	}
+	var buf bytes.Buffer
+	if err := (&idfactor.Config{}).WriteManifestToWriter(first, &buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := idfactor.ReadManifest(&buf, idfactor.Format{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, first) {
+		t.Errorf("read back %v, want %v", read, first)
+	}
+	second := idfactor.Manifest{
+		{File: "c.psv", Element: "record", Rows: 1},
+		{File: "name.psv", Element: "name", Rows: 2},
+		{File: "phone.psv", Element: "phone", Rows: 1},
+	}
+	want := idfactor.Manifest{
+		{File: idfactor.AllInputs, Element: "record", Rows: 6},
+		{File: "name.psv", Element: "name", Rows: 6},
+		{File: "phone.psv", Element: "phone", Rows: 1},
+	}
+	if got := idfactor.MergeManifests(read, second); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+	if _, err := idfactor.ReadManifest(strings.NewReader("a|b\n"), idfactor.Format{}); err == nil {
+		t.Error("bad manifest header accepted")
+	}
+}
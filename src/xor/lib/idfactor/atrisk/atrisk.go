@@ -42,6 +42,9 @@ const (
 	RecordLength
 )
 
+// Header is the column header of at-risk entity input files.
+var Header = []string{"record_id", "first_name", "last_name", "middle_initial", "suffix", "dob", "ssn", "address_line_1", "address_line_2", "city", "state", "zip", "phone", "email", "username"}
+
 var (
 	nameHeader        = []string{"name_id", "first_name", "last_name", "middle_initial", "suffix", "dob"}
 	ssnHeader         = []string{"ssn_id", "ssn"}
@@ -53,6 +56,18 @@ var (
 	userNameHeader    = []string{"username_id", "username"}
 )
 
+// Elements lists the identity element types in identity map column order.
+var Elements = []idfactor.Element{
+	{Name: "name_dob", ID: "name_id", Header: nameHeader, Get: ToNameDob},
+	{Name: "ssn", ID: "ssn_id", Header: ssnHeader, Get: ToSsn},
+	{Name: "address", ID: "address_id", Header: addressHeader, Get: ToAddress},
+	{Name: "phone", ID: "phone_id", Header: phoneHeader, Get: ToPhone},
+	{Name: "email", ID: "email_id", Header: emailHeader, Get: ToEmail},
+	{Name: "name_address", ID: "name_address_id", Header: nameAddressHeader, Get: ToNameAddress},
+	{Name: "name_phone", ID: "name_phone_id", Header: namePhoneHeader, Get: ToNamePhone},
+	{Name: "username", ID: "username_id", Header: userNameHeader, Get: ToUserName},
+}
+
 //------------------------------------------------------------------------------
 // Identity element getters. These functions extract an identity element from
 // a full identity record and insert the given ID in the returned element.
@@ -126,6 +141,8 @@ func ToNameAddress(rec []string, id string) []string {
 	if idfactor.AllEmpty(fields...) {
 		return nil
 	}
+	// Append empty Zip4 field
+	fields = append(fields, "")
 	return append([]string{id}, fields...)
 }
 
@@ -0,0 +1,68 @@
+package idfactor_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"xor/lib/idfactor"
+	"xor/lib/synth"
+)
+
+// record counts exercised by the benchmarks. The largest sizes need several
+// gigabytes of memory; select smaller sizes with -bench, e.g.
+// -bench 'IDFactor/n=1000$'.
+var benchSizes = []int{1e3, 1e4, 1e5, 1e6, 1e7}
+
+// benchRecords caches synthetic data sets between benchmarks since generating
+// them dominates the cost of small runs.
+var benchRecords = make(map[int][][]string)
+
+func records(b *testing.B, n int) [][]string {
+	if recs, ok := benchRecords[n]; ok {
+		return recs
+	}
+	b.StopTimer()
+	recs := synth.New(synth.Config{DuplicateRate: 0.05, EmptyRate: 0.02, Seed: 1}).Records(n)
+	benchRecords[n] = recs
+	b.StartTimer()
+	return recs
+}
+
+func BenchmarkIDFactor(b *testing.B) {
+	factorers := make([]idfactor.Factorer, len(atRiskElements))
+	for i, e := range atRiskElements {
+		e := e
+		factorers[i] = func(recs [][]string) map[string]string {
+			return e.write(recs, io.Discard)
+		}
+	}
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			recs := records(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := idfactor.IDFactor(recs, factorers...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWriteElements(b *testing.B) {
+	for _, e := range atRiskElements {
+		e := e
+		for _, n := range benchSizes[:4] {
+			b.Run(fmt.Sprintf("%s/n=%d", e.name, n), func(b *testing.B) {
+				recs := records(b, n)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					e.write(recs, io.Discard)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package compromised
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"xor/lib/input"
+)
+
+// Partition groups records by breach id. It returns the breach ids in sorted
+// order and, for each breach id, the positions of its records in input order.
+func Partition(recs [][]string) ([]string, map[string][]int) {
+	positions := make(map[string][]int)
+	for i, rec := range recs {
+		positions[rec[BreachIDField]] = append(positions[rec[BreachIDField]], i)
+	}
+	breachIDs := make([]string, 0, len(positions))
+	for id := range positions {
+		breachIDs = append(breachIDs, id)
+	}
+	sort.Strings(breachIDs)
+	return breachIDs, positions
+}
+
+// CheckBreachDir reports whether a breach id can be used as the name of a
+// directory of per breach output. It must be made of letters, digits, dots,
+// hyphens and underscores only, and must not be . or ..
+func CheckBreachDir(id string) error {
+	if id == "" || id == "." || id == ".." {
+		return fmt.Errorf(`compromised: breach id "%s" cannot name a directory`, id)
+	}
+	if i := strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+	}); i >= 0 {
+		return fmt.Errorf(`compromised: breach id "%s" cannot name a directory: it contains %q`, id, id[i:i+1])
+	}
+	return nil
+}
+
+// BreachHeader is the column header of normalized breach metadata.
+var BreachHeader = []string{"breach_id", "name", "date_discovered", "date_of_breach", "source", "data_classes"}
+
+// breachColumns maps accepted breach metadata column names to their
+// positions in BreachHeader
+var breachColumns = map[string]int{
+	"breach_id":            0,
+	"id":                   0,
+	"name":                 1,
+	"breach_name":          1,
+	"date_discovered":      2,
+	"discovered":           2,
+	"discovery_date":       2,
+	"date_of_breach":       3,
+	"breach_date":          3,
+	"date_breached":        3,
+	"source":               4,
+	"data_classes":         5,
+	"data_classes_exposed": 5,
+	"exposed_data":         5,
+}
+
+// Breach is the metadata of a breach.
+type Breach struct {
+	// ID is the breach id used in compromised entity records
+	ID string
+	// Name is a human readable name of the breach
+	Name string
+	// Discovered is the date the breach was discovered, as YYYY-MM-DD, or
+	// empty if unknown
+	Discovered string
+	// Breached is the date of the breach, as YYYY-MM-DD, or empty if
+	// unknown
+	Breached string
+	// Source is where the breach data came from
+	Source string
+	// DataClasses lists the kinds of data exposed, in lower case and sorted
+	DataClasses []string
+}
+
+// Row returns the breach as a row of normalized breach metadata.
+func (b Breach) Row() []string {
+	return []string{b.ID, b.Name, b.Discovered, b.Breached, b.Source, strings.Join(b.DataClasses, ";")}
+}
+
+// dateLayouts are the accepted layouts of breach metadata dates other than
+// those read by slashDate
+var dateLayouts = []string{"2006-01-02", "2006/01/02", "2 January 2006", "January 2, 2006", "Jan 2, 2006", time.RFC3339}
+
+// slashDatePattern matches a date with the day and month, in either order,
+// and the year separated by slashes
+var slashDatePattern = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})/(\d{4})$`)
+
+// slashDate returns a date with its day and month, in either order, and its
+// year separated by slashes as YYYY-MM-DD. Such a date is accepted only if
+// the order does not matter or one of the numbers cannot be a month, since
+// 01/02/2006 is in January in the US but in February in Europe.
+func slashDate(s string) (string, bool, error) {
+	m := slashDatePattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", false, nil
+	}
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[2])
+	month, day := a, b
+	switch {
+	case a == b:
+	case a > 12 && b <= 12:
+		month, day = b, a
+	case b > 12 && a <= 12:
+	default:
+		return "", true, fmt.Errorf(`ambiguous date "%s": day and month cannot be told apart (expected YYYY-MM-DD)`, s)
+	}
+	date := fmt.Sprintf("%s-%02d-%02d", m[3], month, day)
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", true, fmt.Errorf(`bad date "%s"`, s)
+	}
+	return date, true, nil
+}
+
+// normalizeDate returns a date as YYYY-MM-DD
+func normalizeDate(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if date, ok, err := slashDate(s); ok {
+		return date, err
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf(`bad date "%s" (expected YYYY-MM-DD)`, s)
+}
+
+// ReadBreaches reads breach metadata in the given dialect and returns it by
+// breach id. The metadata has a header naming its columns, which may come in
+// any order, and every column but breach_id may be left out. Dates are
+// normalized to YYYY-MM-DD, refusing those with the day and month separated
+// by slashes unless their order is plain, and data classes, separated by
+// commas or semicolons, to a sorted list of distinct lower case names.
+func ReadBreaches(r io.Reader, dialect input.Dialect) (map[string]Breach, error) {
+	reader := input.NewReader(r)
+	reader.Dialect = dialect
+	header, rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("compromised: error reading breach metadata: %w", err)
+	}
+	if header == nil {
+		return nil, fmt.Errorf("compromised: empty breach metadata")
+	}
+	columns := make([]int, len(header))
+	seen := make(map[int]bool)
+	for i, h := range header {
+		name := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '-' {
+				return '_'
+			}
+			return unicode.ToLower(r)
+		}, strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		col, ok := breachColumns[name]
+		if !ok {
+			return nil, fmt.Errorf(`compromised: unknown breach metadata column "%s"`, h)
+		}
+		if seen[col] {
+			return nil, fmt.Errorf(`compromised: breach metadata column "%s" repeated`, BreachHeader[col])
+		}
+		seen[col] = true
+		columns[i] = col
+	}
+	if !seen[0] {
+		return nil, fmt.Errorf("compromised: breach metadata has no breach_id column")
+	}
+
+	breaches := make(map[string]Breach)
+	lines := reader.Lines()
+	for n, row := range rows {
+		line := lines[n]
+		fields := make([]string, len(BreachHeader))
+		for i, v := range row {
+			fields[columns[i]] = strings.TrimSpace(v)
+		}
+		b := Breach{ID: fields[0], Name: fields[1], Source: fields[4]}
+		if b.ID == "" {
+			return nil, fmt.Errorf("compromised: breach metadata line %d: empty breach_id", line)
+		}
+		if _, ok := breaches[b.ID]; ok {
+			return nil, fmt.Errorf("compromised: breach metadata line %d: breach %s repeated", line, b.ID)
+		}
+		if b.Discovered, err = normalizeDate(fields[2]); err != nil {
+			return nil, fmt.Errorf("compromised: breach metadata line %d: date_discovered: %w", line, err)
+		}
+		if b.Breached, err = normalizeDate(fields[3]); err != nil {
+			return nil, fmt.Errorf("compromised: breach metadata line %d: date_of_breach: %w", line, err)
+		}
+		if b.Discovered != "" && b.Breached > b.Discovered {
+			return nil, fmt.Errorf("compromised: breach metadata line %d: breach %s discovered before it happened", line, b.ID)
+		}
+		classes := make(map[string]bool)
+		for _, c := range strings.FieldsFunc(fields[5], func(r rune) bool { return r == ',' || r == ';' }) {
+			if c = strings.ToLower(strings.TrimSpace(c)); c != "" && !classes[c] {
+				classes[c] = true
+				b.DataClasses = append(b.DataClasses, c)
+			}
+		}
+		sort.Strings(b.DataClasses)
+		breaches[b.ID] = b
+	}
+	return breaches, nil
+}
@@ -0,0 +1,86 @@
+package compromised
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"xor/lib/input"
+)
+
+var pipes = input.Dialect{Delimiter: "|", Quote: '"'}
+
+func TestReadBreaches(t *testing.T) {
+	text := "Source|Breach ID|Date Discovered|date_of_breach|data_classes\n" +
+		"forum|B1|March 4, 2023|2023/01/31|Email, SSN;email\n" +
+		"|B2|||\n"
+	breaches, err := ReadBreaches(strings.NewReader(text), pipes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Breach{
+		"B1": {ID: "B1", Discovered: "2023-03-04", Breached: "2023-01-31", Source: "forum", DataClasses: []string{"email", "ssn"}},
+		"B2": {ID: "B2"},
+	}
+	if !reflect.DeepEqual(breaches, want) {
+		t.Errorf("breaches = %v, want %v", breaches, want)
+	}
+	if row := breaches["B1"].Row(); !reflect.DeepEqual(row, []string{"B1", "", "2023-03-04", "2023-01-31", "forum", "email;ssn"}) {
+		t.Errorf("row = %q", row)
+	}
+
+	for _, bad := range []string{
+		"name\nx\n",
+		"breach_id|color\nB1|red\n",
+		"breach_id\nB1\nB1\n",
+		"breach_id|date_discovered\nB1|yesterday\n",
+		"breach_id|date_discovered|date_of_breach\nB1|2020-01-01|2021-01-01\n",
+		"breach_id|date_discovered\nB1|01/02/2023\n",
+		"breach_id|date_discovered\nB1|31/02/2023\n",
+	} {
+		if _, err := ReadBreaches(strings.NewReader(bad), pipes); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+func TestReadBreachesDialect(t *testing.T) {
+	text := "breach_id;name;date_discovered;date_of_breach\n" +
+		"B1;'Forum; 2023';13/01/2023;5/5/2022\n" +
+		"B2;Shop;01/13/2023;\n"
+	breaches, err := ReadBreaches(strings.NewReader(text), input.Dialect{Delimiter: ";", Quote: '\''})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Breach{
+		"B1": {ID: "B1", Name: "Forum; 2023", Discovered: "2023-01-13", Breached: "2022-05-05"},
+		"B2": {ID: "B2", Name: "Shop", Discovered: "2023-01-13"},
+	}
+	if !reflect.DeepEqual(breaches, want) {
+		t.Errorf("breaches = %v, want %v", breaches, want)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	recs := [][]string{{"r1", "B2"}, {"r2", "B1"}, {"r3", "B2"}, {"r4", ""}}
+	breachIDs, positions := Partition(recs)
+	if want := []string{"", "B1", "B2"}; !reflect.DeepEqual(breachIDs, want) {
+		t.Errorf("breach ids = %q, want %q", breachIDs, want)
+	}
+	if want := map[string][]int{"": {3}, "B1": {1}, "B2": {0, 2}}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("positions = %v, want %v", positions, want)
+	}
+}
+
+func TestCheckBreachDir(t *testing.T) {
+	for _, id := range []string{"B1", "breach-2023_01.x", "..a"} {
+		if err := CheckBreachDir(id); err != nil {
+			t.Errorf("%q: %s", id, err)
+		}
+	}
+	for _, id := range []string{"", ".", "..", "../B1", "a/b", `a\b`, "B 1", "Bé"} {
+		if err := CheckBreachDir(id); err == nil {
+			t.Errorf("%q was accepted", id)
+		}
+	}
+}
@@ -44,6 +44,9 @@ const (
 	RecordLength
 )
 
+// Header is the column header of compromised entity input files.
+var Header = []string{"record_id", "breach_id", "first_name", "last_name", "middle_initial", "suffix", "dob", "ssn", "address_line_1", "address_line_2", "city", "state", "zip", "phone", "email", "username"}
+
 var (
 	nameHeader        = []string{"breach_id", "name_id", "first_name", "last_name", "middle_initial", "suffix", "dob"}
 	ssnHeader         = []string{"breach_id", "ssn_id", "ssn"}
@@ -55,6 +58,18 @@ var (
 	userNameHeader    = []string{"breach_id", "username_id", "username"}
 )
 
+// Elements lists the identity element types in identity map column order.
+var Elements = []idfactor.Element{
+	{Name: "name_dob", ID: "name_id", Header: nameHeader, Get: ToNameDob},
+	{Name: "ssn", ID: "ssn_id", Header: ssnHeader, Get: ToSsn},
+	{Name: "address", ID: "address_id", Header: addressHeader, Get: ToAddress},
+	{Name: "phone", ID: "phone_id", Header: phoneHeader, Get: ToPhone},
+	{Name: "email", ID: "email_id", Header: emailHeader, Get: ToEmail},
+	{Name: "name_address", ID: "name_address_id", Header: nameAddressHeader, Get: ToNameAddress},
+	{Name: "name_phone", ID: "name_phone_id", Header: namePhoneHeader, Get: ToNamePhone},
+	{Name: "username", ID: "username_id", Header: userNameHeader, Get: ToUserName},
+}
+
 //------------------------------------------------------------------------------
 // Identity element getters. These functions extract an identity element from
 // a full identity record and insert the given ID in the returned element.
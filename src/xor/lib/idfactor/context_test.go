@@ -0,0 +1,91 @@
+package idfactor_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xor/lib/idfactor"
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/synth"
+)
+
+func TestIDFactorContextCancelled(t *testing.T) {
+	dir := t.TempDir()
+	recs := synth.New(synth.Config{Seed: 1}).Records(1000)
+	factorers := make([]idfactor.FactorerContext, len(atrisk.Elements))
+	for i, e := range atrisk.Elements {
+		factorers[i] = (&idfactor.Config{}).FactorerContext(e, filepath.Join(dir, e.Name))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := idfactor.IDFactorContext(ctx, recs, factorers...); !errors.Is(err, context.Canceled) {
+		t.Fatalf("IDFactorContext error = %v, want context.Canceled", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d partially written files left behind", len(files))
+	}
+}
+
+func TestIDFactorContextFirstError(t *testing.T) {
+	failure := errors.New("disk full")
+	stopped := make(chan bool, 1)
+	failing := func(ctx context.Context, recs [][]string) (map[string]string, error) {
+		return nil, failure
+	}
+	waiting := func(ctx context.Context, recs [][]string) (map[string]string, error) {
+		<-ctx.Done()
+		stopped <- true
+		return nil, ctx.Err()
+	}
+	recs := synth.New(synth.Config{Seed: 1}).Records(10)
+	if _, err := idfactor.IDFactorContext(context.Background(), recs, waiting, failing); !errors.Is(err, failure) {
+		t.Fatalf("IDFactorContext error = %v, want %v", err, failure)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("remaining factorer was not cancelled")
+	}
+}
+
+func TestIDFactorContextWaits(t *testing.T) {
+	stopped := false
+	slow := func(ctx context.Context, recs [][]string) (map[string]string, error) {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		stopped = true
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	recs := synth.New(synth.Config{Seed: 1}).Records(10)
+	if _, err := idfactor.IDFactorContext(ctx, recs, slow); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("IDFactorContext error = %v, want context.DeadlineExceeded", err)
+	}
+	if !stopped {
+		t.Error("IDFactorContext returned before the factorer stopped")
+	}
+}
+
+func TestIDFactorContextMatchesIDFactor(t *testing.T) {
+	dir := t.TempDir()
+	recs := synth.New(synth.Config{Seed: 1, EmptyRate: 0.2}).Records(100)
+	factorers := make([]idfactor.FactorerContext, len(atrisk.Elements))
+	for i, e := range atrisk.Elements {
+		factorers[i] = (&idfactor.Config{}).FactorerContext(e, filepath.Join(dir, e.Name))
+	}
+	ids, err := idfactor.IDFactorContext(context.Background(), recs, factorers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(recs) || len(ids[0]) != 1+len(atrisk.Elements) {
+		t.Fatalf("identity map is %dx%d", len(ids), len(ids[0]))
+	}
+	if files, _ := os.ReadDir(dir); len(files) != len(atrisk.Elements) {
+		t.Errorf("%d element files written, want %d", len(files), len(atrisk.Elements))
+	}
+}
@@ -0,0 +1,88 @@
+package idfactor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"xor/lib/shuffle"
+)
+
+//------------------------------------------------------------------------------
+// Pseudonymous record ids. The source record ids are replaced in the identity
+// map by generated surrogates, and a separate crosswalk maps each source
+// record id to its surrogate, so that the map alone reveals no source ids.
+//------------------------------------------------------------------------------
+
+// CrosswalkHeader is the column header of a record id crosswalk.
+var CrosswalkHeader = []string{"record_id", "surrogate_id"}
+
+// Pseudonymize replaces the record id of each row of an identity map with a
+// new surrogate id. It returns the new identity map and the crosswalk from
+// record ids to surrogate ids. The given identity map is not modified.
+//
+// The rows of the new map are shuffled, and their surrogates generated in the
+// shuffled order, so that neither the order of the rows nor that of time
+// ordered surrogates reveals the order of the record ids. The crosswalk is
+// sorted by record id.
+func (c *Config) Pseudonymize(ids [][]string) (pseudonymized, crosswalk [][]string) {
+	src, gen := c.source(), c.generator()
+	pseudonymized = make([][]string, len(ids))
+	crosswalk = make([][]string, len(ids))
+	for n, i := range shuffle.New(src).Shuffle(len(ids)) {
+		surrogate := gen.New(src)
+		pseudonymized[n] = append([]string{surrogate}, ids[i][1:]...)
+		crosswalk[n] = []string{ids[i][0], surrogate}
+	}
+	slices.SortFunc(crosswalk, byRecordID)
+	return pseudonymized, crosswalk
+}
+
+// byRecordID orders crosswalk rows by record id
+func byRecordID(a, b []string) int {
+	return strings.Compare(a[0], b[0])
+}
+
+// WriteCrosswalkToFileContext writes a record id crosswalk to the file with
+// the given name. With Append the crosswalk is merged into the existing one,
+// keeping it sorted by record id. It stops and returns an error if ctx is
+// done first.
+func (c *Config) WriteCrosswalkToFileContext(ctx context.Context, crosswalk [][]string, name string) error {
+	return c.writeSortedFile(ctx, name, byRecordID, func(w io.Writer) error {
+		return c.WriteCrosswalkToWriterContext(ctx, crosswalk, w)
+	})
+}
+
+// WriteCrosswalkToWriterContext calls Config.WriteCrosswalkToWriterContext on
+// the zero Config.
+func WriteCrosswalkToWriterContext(ctx context.Context, crosswalk [][]string, w io.Writer) error {
+	return (&Config{}).WriteCrosswalkToWriterContext(ctx, crosswalk, w)
+}
+
+// WriteCrosswalkToWriterContext writes a record id crosswalk to the given
+// io.Writer. It stops and returns an error if ctx is done first.
+func (c *Config) WriteCrosswalkToWriterContext(ctx context.Context, crosswalk [][]string, w io.Writer) error {
+	return c.writeRows(ctx, w, CrosswalkHeader, crosswalk, "crosswalk")
+}
+
+// ReadCrosswalk reads a record id crosswalk written in the given format and
+// returns a map from surrogate ids to record ids.
+func ReadCrosswalk(r io.Reader, f Format) (map[string]string, error) {
+	rows, err := f.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("idfactor: error reading crosswalk: %w", err)
+	}
+	if len(rows) == 0 || len(rows[0]) != len(CrosswalkHeader) || rows[0][0] != CrosswalkHeader[0] || rows[0][1] != CrosswalkHeader[1] {
+		return nil, fmt.Errorf("idfactor: bad crosswalk header")
+	}
+	recordIDs := make(map[string]string, len(rows)-1)
+	for _, row := range rows[1:] {
+		if _, ok := recordIDs[row[1]]; ok {
+			return nil, fmt.Errorf("idfactor: surrogate id %s repeated in crosswalk", row[1])
+		}
+		recordIDs[row[1]] = row[0]
+	}
+	return recordIDs, nil
+}
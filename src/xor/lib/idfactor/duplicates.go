@@ -0,0 +1,182 @@
+package idfactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//------------------------------------------------------------------------------
+// Duplicate record ids. Identity maps are keyed by record id, so records that
+// share one would be mixed together. These functions find such records before
+// factoring and resolve them according to a policy.
+//------------------------------------------------------------------------------
+
+// DuplicatePolicy says how records with a repeated record id are resolved.
+type DuplicatePolicy int
+
+const (
+	// DuplicateFail rejects input with repeated record ids
+	DuplicateFail DuplicatePolicy = iota
+	// DuplicateKeepFirst keeps the first record with each record id
+	DuplicateKeepFirst
+	// DuplicateKeepLast keeps the last record with each record id
+	DuplicateKeepLast
+	// DuplicateSuffix keeps every record and renames the second and later
+	// records with each record id by appending -2, -3, ... to it
+	DuplicateSuffix
+)
+
+// DuplicatePolicies lists the names of the duplicate policies.
+var DuplicatePolicies = []string{"fail", "first", "last", "suffix"}
+
+// ParseDuplicatePolicy returns the duplicate policy with the given name.
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	for i, p := range DuplicatePolicies {
+		if p == name {
+			return DuplicatePolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf(`idfactor: unknown duplicate policy "%s"`, name)
+}
+
+// ErrDuplicate is returned when input has repeated record ids and the policy
+// is DuplicateFail.
+var ErrDuplicate = errors.New("idfactor: duplicate record ids")
+
+// Collision describes a record whose record id is shared with another record.
+type Collision struct {
+	// RecordID is the shared record id
+	RecordID string
+	// Record is the position of the record in the input, counting from 1
+	Record int
+	// File and Line locate the record in its input file, if known. They are
+	// not set by ResolveDuplicates.
+	File string
+	Line int
+	// Action is what was done with the record: "kept", "dropped",
+	// "renamed" or, when the input is rejected, "rejected"
+	Action string
+	// NewRecordID is the record id of a renamed record
+	NewRecordID string
+}
+
+// CollisionHeader is the column header of a collision report.
+var CollisionHeader = []string{"record_id", "record", "file", "line", "action", "new_record_id"}
+
+// ResolveDuplicates finds records with repeated record ids and resolves them
+// according to policy. It returns the resolved records and a report of every
+// record involved in a collision, in input order. The given records are not
+// modified. With DuplicateFail an error wrapping ErrDuplicate is returned if
+// there are any collisions.
+func ResolveDuplicates(recs [][]string, policy DuplicatePolicy) ([][]string, []Collision, error) {
+	return ResolveDuplicatesWithPrior(recs, nil, policy)
+}
+
+// ResolveDuplicatesWithPrior is like ResolveDuplicates but also treats the
+// record ids used by prior runs as taken by records that come before recs.
+// Those records are already delivered, so DuplicateKeepFirst drops every
+// record that reuses a prior record id, DuplicateSuffix renames them all, and
+// DuplicateKeepLast, which would have to replace them, returns an error.
+func ResolveDuplicatesWithPrior(recs [][]string, prior []string, policy DuplicatePolicy) ([][]string, []Collision, error) {
+	// positions of the records with each record id, with -1 for prior runs
+	positions := make(map[string][]int, len(prior)+len(recs))
+	for _, id := range prior {
+		positions[id] = []int{-1}
+	}
+	for i, rec := range recs {
+		id := rec[recordIDField]
+		positions[id] = append(positions[id], i)
+	}
+	if len(positions) == len(prior)+len(recs) {
+		return recs, nil, nil
+	}
+
+	var collisions []Collision
+	resolved := make([][]string, 0, len(positions))
+	for i, rec := range recs {
+		id := rec[recordIDField]
+		same := positions[id]
+		if len(same) == 1 {
+			resolved = append(resolved, rec)
+			continue
+		}
+		c := Collision{RecordID: id, Record: i + 1}
+		switch policy {
+		case DuplicateFail:
+			c.Action = "rejected"
+		case DuplicateKeepLast:
+			if same[0] < 0 {
+				return nil, nil, fmt.Errorf("%w: record %d reuses record id %s of a prior run, which cannot be replaced", ErrDuplicate, i+1, id)
+			}
+			fallthrough
+		case DuplicateKeepFirst:
+			keep := same[0]
+			if policy == DuplicateKeepLast {
+				keep = same[len(same)-1]
+			}
+			c.Action = "dropped"
+			if i == keep {
+				c.Action = "kept"
+				resolved = append(resolved, rec)
+			}
+		case DuplicateSuffix:
+			c.Action = "kept"
+			if i != same[0] {
+				c.Action = "renamed"
+				c.NewRecordID = suffix(id, positions)
+				positions[c.NewRecordID] = []int{i}
+				rec = append([]string{c.NewRecordID}, rec[recordIDField+1:]...)
+			}
+			resolved = append(resolved, rec)
+		default:
+			return nil, nil, fmt.Errorf("idfactor: unknown duplicate policy %d", policy)
+		}
+		collisions = append(collisions, c)
+	}
+	if policy == DuplicateFail {
+		return nil, collisions, fmt.Errorf("%w: %d records share %d record ids", ErrDuplicate, len(collisions), countIDs(collisions))
+	}
+	return resolved, collisions, nil
+}
+
+// suffix returns the first record id of the form id-n, n > 1, that is not in
+// use
+func suffix(id string, used map[string][]int) string {
+	for n := 2; ; n++ {
+		s := id + "-" + strconv.Itoa(n)
+		if _, ok := used[s]; !ok {
+			return s
+		}
+	}
+}
+
+// countIDs returns the number of distinct record ids in a collision report
+func countIDs(collisions []Collision) int {
+	ids := make(map[string]bool)
+	for _, c := range collisions {
+		ids[c.RecordID] = true
+	}
+	return len(ids)
+}
+
+// WriteCollisionsToWriter calls Config.WriteCollisionsToWriter on the zero
+// Config.
+func WriteCollisionsToWriter(collisions []Collision, w io.Writer) error {
+	return (&Config{}).WriteCollisionsToWriter(collisions, w)
+}
+
+// WriteCollisionsToWriter writes a collision report to the given io.Writer.
+func (c *Config) WriteCollisionsToWriter(collisions []Collision, w io.Writer) error {
+	rows := make([][]string, len(collisions))
+	for i, col := range collisions {
+		line := ""
+		if col.Line > 0 {
+			line = strconv.Itoa(col.Line)
+		}
+		rows[i] = []string{col.RecordID, strconv.Itoa(col.Record), col.File, line, col.Action, col.NewRecordID}
+	}
+	return c.writeRows(context.Background(), w, CollisionHeader, rows, "collision report")
+}
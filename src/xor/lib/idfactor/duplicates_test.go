@@ -0,0 +1,111 @@
+package idfactor_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"xor/lib/idfactor"
+)
+
+func TestResolveDuplicates(t *testing.T) {
+	recs := [][]string{
+		{"A", "a1"},
+		{"B", "b1"},
+		{"A", "a2"},
+		{"A-2", "c1"},
+		{"A", "a3"},
+	}
+	for _, tc := range []struct {
+		policy     idfactor.DuplicatePolicy
+		want       [][]string
+		collisions []idfactor.Collision
+	}{
+		{
+			idfactor.DuplicateKeepFirst,
+			[][]string{{"A", "a1"}, {"B", "b1"}, {"A-2", "c1"}},
+			[]idfactor.Collision{{"A", 1, "", 0, "kept", ""}, {"A", 3, "", 0, "dropped", ""}, {"A", 5, "", 0, "dropped", ""}},
+		},
+		{
+			idfactor.DuplicateKeepLast,
+			[][]string{{"B", "b1"}, {"A-2", "c1"}, {"A", "a3"}},
+			[]idfactor.Collision{{"A", 1, "", 0, "dropped", ""}, {"A", 3, "", 0, "dropped", ""}, {"A", 5, "", 0, "kept", ""}},
+		},
+		{
+			idfactor.DuplicateSuffix,
+			[][]string{{"A", "a1"}, {"B", "b1"}, {"A-3", "a2"}, {"A-2", "c1"}, {"A-4", "a3"}},
+			[]idfactor.Collision{{"A", 1, "", 0, "kept", ""}, {"A", 3, "", 0, "renamed", "A-3"}, {"A", 5, "", 0, "renamed", "A-4"}},
+		},
+	} {
+		got, collisions, err := idfactor.ResolveDuplicates(recs, tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := idfactor.DuplicatePolicies[tc.policy]
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got records %v, want %v", name, got, tc.want)
+		}
+		if !reflect.DeepEqual(collisions, tc.collisions) {
+			t.Errorf("%s: got collisions %v, want %v", name, collisions, tc.collisions)
+		}
+	}
+	if recs[2][0] != "A" {
+		t.Error("input records were modified")
+	}
+
+	_, collisions, err := idfactor.ResolveDuplicates(recs, idfactor.DuplicateFail)
+	if !errors.Is(err, idfactor.ErrDuplicate) {
+		t.Errorf("fail: got error %v, want ErrDuplicate", err)
+	}
+	if len(collisions) != 3 {
+		t.Errorf("fail: got %d collisions, want 3", len(collisions))
+	}
+
+	unique := [][]string{{"A"}, {"B"}}
+	got, collisions, err := idfactor.ResolveDuplicates(unique, idfactor.DuplicateFail)
+	if err != nil || collisions != nil || !reflect.DeepEqual(got, unique) {
+		t.Errorf("unique record ids: got %v, %v, %v", got, collisions, err)
+	}
+}
+
+func TestResolveDuplicatesWithPrior(t *testing.T) {
+	recs := [][]string{{"A", "a1"}, {"B", "b1"}, {"A", "a2"}}
+	prior := []string{"A", "A-2"}
+
+	got, collisions, err := idfactor.ResolveDuplicatesWithPrior(recs, prior, idfactor.DuplicateKeepFirst)
+	want := [][]string{{"B", "b1"}}
+	if err != nil || !reflect.DeepEqual(got, want) || len(collisions) != 2 {
+		t.Errorf("first: got %v, %v, %v", got, collisions, err)
+	}
+
+	got, collisions, err = idfactor.ResolveDuplicatesWithPrior(recs, prior, idfactor.DuplicateSuffix)
+	want = [][]string{{"A-3", "a1"}, {"B", "b1"}, {"A-4", "a2"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("suffix: got %v, %v", got, err)
+	}
+	if want := []idfactor.Collision{{"A", 1, "", 0, "renamed", "A-3"}, {"A", 3, "", 0, "renamed", "A-4"}}; !reflect.DeepEqual(collisions, want) {
+		t.Errorf("suffix: got collisions %v, want %v", collisions, want)
+	}
+
+	for _, policy := range []idfactor.DuplicatePolicy{idfactor.DuplicateFail, idfactor.DuplicateKeepLast} {
+		if _, _, err := idfactor.ResolveDuplicatesWithPrior(recs, prior, policy); !errors.Is(err, idfactor.ErrDuplicate) {
+			t.Errorf("%s: got error %v, want ErrDuplicate", idfactor.DuplicatePolicies[policy], err)
+		}
+	}
+}
+
+func TestWriteCollisions(t *testing.T) {
+	collisions := []idfactor.Collision{
+		{RecordID: "A", Record: 1, File: "a/in.psv", Line: 2, Action: "kept"},
+		{RecordID: "A", Record: 5, Action: "renamed", NewRecordID: "A-2"},
+	}
+	var buf bytes.Buffer
+	if err := idfactor.WriteCollisionsToWriter(collisions, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "record_id|record|file|line|action|new_record_id\nA|1|a/in.psv|2|kept|\nA|5|||renamed|A-2\n"
+	if buf.String() != want {
+		t.Errorf("report = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,182 @@
+package idfactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+// Erasure of delivered records. The rows of erased records are removed from
+// the identity maps and their elements from the element files, and an audit
+// record of what was erased is kept.
+//------------------------------------------------------------------------------
+
+// AuditHeader is the column header of an erasure audit record.
+var AuditHeader = []string{"time", "record_id", "element", "element_id", "action"}
+
+// AuditEntry is one row of an erasure audit record.
+type AuditEntry struct {
+	// RecordID is the record id of the erased record
+	RecordID string
+	// Element is the element type of an element of the record
+	Element string
+	// ElementID is the element id of an element of the record
+	ElementID string
+	// Action is what was done: "erased", "kept shared" for an element that
+	// another record also refers to, or "not found" for a record id that is
+	// not in the map
+	Action string
+}
+
+// Erasure is the set of ids to remove from a delivery to erase some of its
+// records.
+type Erasure struct {
+	// RecordIDs are the record ids of the erased records
+	RecordIDs map[string]bool
+	// ElementIDs are the element ids to remove from the element files
+	ElementIDs map[string]bool
+	// Audit lists the erased records and elements
+	Audit []AuditEntry
+}
+
+// Erase returns the erasure of the records with the given record ids. The
+// elements of an erased record are erased unless a record that is not erased
+// refers to them too. elements lists the element types.
+func (m *IdentityMap) Erase(recordIDs []string, elements []Element) *Erasure {
+	erasure := &Erasure{RecordIDs: make(map[string]bool), ElementIDs: make(map[string]bool)}
+	for _, id := range recordIDs {
+		if _, ok := m.IDs[id]; ok {
+			erasure.RecordIDs[id] = true
+		}
+	}
+	// element ids referred to by the records that remain
+	kept := make(map[string]bool)
+	for recordID, ids := range m.IDs {
+		if erasure.RecordIDs[recordID] {
+			continue
+		}
+		for _, id := range ids {
+			kept[id] = true
+		}
+	}
+	for _, recordID := range recordIDs {
+		if !erasure.RecordIDs[recordID] {
+			erasure.Audit = append(erasure.Audit, AuditEntry{RecordID: recordID, Action: "not found"})
+			continue
+		}
+		erasure.Audit = append(erasure.Audit, AuditEntry{RecordID: recordID, Action: "erased"})
+		for _, e := range elements {
+			id := m.IDs[recordID][e.ID]
+			if id == "" {
+				continue
+			}
+			entry := AuditEntry{recordID, e.Name, id, "erased"}
+			if kept[id] {
+				entry.Action = "kept shared"
+			} else {
+				erasure.ElementIDs[id] = true
+			}
+			erasure.Audit = append(erasure.Audit, entry)
+		}
+	}
+	return erasure
+}
+
+// EraseFromFileContext rewrites the named file without the rows whose value
+// in the named column is one of ids, and returns the number of rows removed.
+// The file is read from and written to the configured file system in the
+// configured format. It stops and returns an error if ctx is done first.
+func (c *Config) EraseFromFileContext(ctx context.Context, name, column string, ids map[string]bool) (int, error) {
+	fs := c.FS
+	if fs == nil {
+		fs = DirFS{}
+	}
+	r, err := fs.Open(name)
+	if err != nil {
+		return 0, fmt.Errorf("idfactor: error opening file: %w", err)
+	}
+	rows, err := c.Format.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return 0, fmt.Errorf(`idfactor: error reading "%s": %w`, name, err)
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf(`idfactor: "%s" has no header`, name)
+	}
+	col := -1
+	for i, h := range rows[0] {
+		if h == column {
+			col = i
+		}
+	}
+	if col < 0 {
+		return 0, fmt.Errorf(`idfactor: "%s" has no %s column`, name, column)
+	}
+	kept := rows[:1]
+	for _, row := range rows[1:] {
+		if !ids[row[col]] {
+			kept = append(kept, row)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("idfactor: erasing stopped: %w", err)
+	}
+	if err := c.WriteRowsToFileContext(ctx, kept[0], kept[1:], name); err != nil {
+		return 0, err
+	}
+	return len(rows) - len(kept), nil
+}
+
+// WriteAuditToFileContext adds the entries of an erasure made at time t to the
+// audit record in the file with the given name, after those already there,
+// creating it if there is none. It stops and returns an error if ctx is done
+// first.
+func (c *Config) WriteAuditToFileContext(ctx context.Context, audit []AuditEntry, t time.Time, name string) error {
+	fs := c.FS
+	if fs == nil {
+		fs = DirFS{}
+	}
+	var rows [][]string
+	r, err := fs.Open(name)
+	switch {
+	case err == nil:
+		rows, err = c.Format.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf(`idfactor: error reading "%s": %w`, name, err)
+		}
+		if len(rows) > 0 {
+			if !slices.Equal(rows[0], AuditHeader) {
+				return fmt.Errorf(`idfactor: "%s" is not an audit record`, name)
+			}
+			rows = rows[1:]
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("idfactor: error opening file: %w", err)
+	}
+	rows = append(rows, auditRows(audit, t)...)
+	config := *c
+	config.Append = false
+	return config.WriteRowsToFileContext(ctx, AuditHeader, rows, name)
+}
+
+// WriteAuditToWriter writes an erasure audit record made at time t to the
+// given io.Writer.
+func (c *Config) WriteAuditToWriter(audit []AuditEntry, t time.Time, w io.Writer) error {
+	return c.writeRows(context.Background(), w, AuditHeader, auditRows(audit, t), "audit record")
+}
+
+// auditRows returns the rows of an erasure audit record made at time t
+func auditRows(audit []AuditEntry, t time.Time) [][]string {
+	stamp := t.UTC().Format(time.RFC3339)
+	rows := make([][]string, len(audit))
+	for i, entry := range audit {
+		rows[i] = []string{stamp, entry.RecordID, entry.Element, entry.ElementID, entry.Action}
+	}
+	return rows
+}
@@ -0,0 +1,85 @@
+package idfactor_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"xor/lib/idfactor"
+)
+
+func TestErase(t *testing.T) {
+	dir := t.TempDir()
+	elements := []idfactor.Element{{Name: "ssn", ID: "ssn_id"}, {Name: "email", ID: "email_id"}}
+	files := map[string]string{
+		"map.psv":   "record_id|ssn_id|email_id\nr1|s1|e1\nr2|s2|e1\nr3|s3|\n",
+		"ssn.psv":   "ssn_id|ssn\ns1|111\ns2|222\ns3|333\n",
+		"email.psv": "email_id|email\ne1|a@example.org\n",
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := idfactor.NewIdentityMap()
+	if err := m.Read(strings.NewReader(files["map.psv"])); err != nil {
+		t.Fatal(err)
+	}
+
+	// e1 is shared with r2, which is not erased
+	erasure := m.Erase([]string{"r1", "r9"}, elements)
+	want := []idfactor.AuditEntry{
+		{RecordID: "r1", Action: "erased"},
+		{RecordID: "r1", Element: "ssn", ElementID: "s1", Action: "erased"},
+		{RecordID: "r1", Element: "email", ElementID: "e1", Action: "kept shared"},
+		{RecordID: "r9", Action: "not found"},
+	}
+	if !reflect.DeepEqual(erasure.Audit, want) {
+		t.Errorf("audit = %v, want %v", erasure.Audit, want)
+	}
+
+	tx := &idfactor.Transaction{Overwrite: true}
+	config := &idfactor.Config{FS: idfactor.DirFS{Dir: dir}, Tx: tx}
+	ctx := context.Background()
+	for _, f := range []struct {
+		name, column string
+		ids          map[string]bool
+		removed      int
+	}{
+		{"map.psv", "record_id", erasure.RecordIDs, 1},
+		{"ssn.psv", "ssn_id", erasure.ElementIDs, 1},
+		{"email.psv", "email_id", erasure.ElementIDs, 0},
+	} {
+		n, err := config.EraseFromFileContext(ctx, f.name, f.column, f.ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != f.removed {
+			t.Errorf("%s: removed %d rows, want %d", f.name, n, f.removed)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(dir, "map.psv"), "record_id|ssn_id|email_id\nr2|s2|e1\nr3|s3|\n")
+	checkFile(t, filepath.Join(dir, "ssn.psv"), "ssn_id|ssn\ns2|222\ns3|333\n")
+	checkFile(t, filepath.Join(dir, "email.psv"), files["email.psv"])
+
+	// a later erasure is added to the audit record in order, even in
+	// append mode
+	config = &idfactor.Config{FS: idfactor.DirFS{Dir: dir}, Append: true}
+	for i, audit := range [][]idfactor.AuditEntry{want[:2], want[3:]} {
+		at := time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := config.WriteAuditToFileContext(ctx, audit, at, "erasure-audit.psv"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFile(t, filepath.Join(dir, "erasure-audit.psv"), "time|record_id|element|element_id|action\n"+
+		"2024-01-01T00:00:00Z|r1|||erased\n"+
+		"2024-01-01T00:00:00Z|r1|ssn|s1|erased\n"+
+		"2024-01-02T00:00:00Z|r9|||not found\n")
+}
@@ -0,0 +1,171 @@
+package idfactor
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"xor/lib/input"
+)
+
+//------------------------------------------------------------------------------
+// Output format. Every output file is written in the same explicit format, so
+// a run produces the same bytes on every platform.
+//------------------------------------------------------------------------------
+
+// QuotePolicy says when output fields are quoted.
+type QuotePolicy int
+
+const (
+	// QuoteMinimal quotes only fields that contain the delimiter, a quote or
+	// a line break, or that start with a space
+	QuoteMinimal QuotePolicy = iota
+	// QuoteAlways quotes every field
+	QuoteAlways
+	// QuoteNever never quotes fields. The delimiter, the escape character
+	// and line breaks are escaped with the escape character instead.
+	QuoteNever
+)
+
+// QuotePolicies lists the names of the quote policies.
+var QuotePolicies = []string{"minimal", "always", "never"}
+
+// ParseQuotePolicy returns the quote policy with the given name.
+func ParseQuotePolicy(name string) (QuotePolicy, error) {
+	for i, p := range QuotePolicies {
+		if p == name {
+			return QuotePolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf(`idfactor: unknown quote policy "%s"`, name)
+}
+
+// Format describes the layout of output files. The zero Format writes pipe
+// delimited fields, quoted only where needed, with LF line endings.
+type Format struct {
+	// Comma is the field delimiter. If zero, '|' is used.
+	Comma rune
+	// Quote is the quote policy
+	Quote QuotePolicy
+	// Escape is the escape character used with QuoteNever. If zero, '\' is
+	// used.
+	Escape rune
+	// CRLF selects CRLF line endings instead of LF
+	CRLF bool
+}
+
+// comma returns the field delimiter
+func (f Format) comma() rune {
+	if f.Comma == 0 {
+		return '|'
+	}
+	return f.Comma
+}
+
+// escape returns the escape character
+func (f Format) escape() rune {
+	if f.Escape == 0 {
+		return '\\'
+	}
+	return f.Escape
+}
+
+// Validate reports whether the format can be written unambiguously.
+func (f Format) Validate() error {
+	switch c := f.comma(); {
+	case c == '"' || c == '\r' || c == '\n':
+		return fmt.Errorf("idfactor: invalid delimiter %q", c)
+	case f.Quote == QuoteNever && c == f.escape():
+		return fmt.Errorf("idfactor: delimiter and escape character are both %q", c)
+	}
+	if f.Quote < QuoteMinimal || f.Quote > QuoteNever {
+		return fmt.Errorf("idfactor: unknown quote policy %d", f.Quote)
+	}
+	return nil
+}
+
+// ReadAll reads every row, the header included, of a file written in the
+// format, so that files written with any quote policy can be read back.
+func (f Format) ReadAll(r io.Reader) ([][]string, error) {
+	reader := input.NewReader(r)
+	reader.Delimiter = string(f.comma())
+	if f.Quote == QuoteNever {
+		reader.Quote, reader.Escape = 0, f.escape()
+	}
+	header, rows, err := reader.ReadAll()
+	if header == nil || err != nil {
+		return nil, err
+	}
+	return append([][]string{header}, rows...), nil
+}
+
+// NewWriter returns a writer of rows to w in the format.
+func (f Format) NewWriter(w io.Writer) input.RowWriter {
+	if f.Quote == QuoteMinimal {
+		writer := csv.NewWriter(w)
+		writer.Comma = f.comma()
+		writer.UseCRLF = f.CRLF
+		return writer
+	}
+	return &formatWriter{f: f, w: bufio.NewWriter(w)}
+}
+
+// formatWriter writes rows that are always or never quoted
+type formatWriter struct {
+	f   Format
+	w   *bufio.Writer
+	err error
+}
+
+// Write writes a row.
+func (fw *formatWriter) Write(row []string) error {
+	if fw.err != nil {
+		return fw.err
+	}
+	comma, esc := fw.f.comma(), fw.f.escape()
+	for i, field := range row {
+		if i > 0 {
+			fw.w.WriteRune(comma)
+		}
+		if fw.f.Quote == QuoteAlways {
+			fw.w.WriteByte('"')
+			fw.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+			fw.w.WriteByte('"')
+			continue
+		}
+		for _, r := range field {
+			switch r {
+			case comma, esc:
+				fw.w.WriteRune(esc)
+				fw.w.WriteRune(r)
+			case '\n':
+				fw.w.WriteRune(esc)
+				fw.w.WriteByte('n')
+			case '\r':
+				fw.w.WriteRune(esc)
+				fw.w.WriteByte('r')
+			default:
+				fw.w.WriteRune(r)
+			}
+		}
+	}
+	if fw.f.CRLF {
+		fw.w.WriteByte('\r')
+	}
+	_, fw.err = fw.w.WriteRune('\n')
+	return fw.err
+}
+
+// Flush writes any buffered data.
+func (fw *formatWriter) Flush() {
+	if fw.err == nil {
+		fw.err = fw.w.Flush()
+	}
+}
+
+// Error returns the first error that occurred while writing.
+func (fw *formatWriter) Error() error {
+	return fw.err
+}
@@ -0,0 +1,55 @@
+package idfactor
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	rows := [][]string{{"a", "b|c", `d"e`}, {"", " f", "g\nh\\i"}}
+	for _, tc := range []struct {
+		name string
+		f    Format
+		want string
+	}{
+		{"default", Format{}, "a|\"b|c\"|\"d\"\"e\"\n|\" f\"|\"g\nh\\i\"\n"},
+		{"always crlf", Format{Quote: QuoteAlways, CRLF: true}, "\"a\"|\"b|c\"|\"d\"\"e\"\r\n\"\"|\" f\"|\"g\nh\\i\"\r\n"},
+		{"never", Format{Quote: QuoteNever}, "a|b\\|c|d\"e\n| f|g\\nh\\\\i\n"},
+		{"never tab", Format{Comma: '\t', Quote: QuoteNever, Escape: '^'}, "a\tb|c\td\"e\n\t f\tg^nh\\i\n"},
+		{"minimal unicode", Format{Comma: '¦'}, "a¦b|c¦\"d\"\"e\"\n¦\" f\"¦\"g\nh\\i\"\n"},
+	} {
+		var buf bytes.Buffer
+		w := tc.f.NewWriter(&buf)
+		for _, row := range rows {
+			if err := w.Write(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		read, err := tc.f.ReadAll(&buf)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if !reflect.DeepEqual(read, rows) {
+			t.Errorf("%s: read back %q, want %q", tc.name, read, rows)
+		}
+	}
+}
+
+func TestFormatValidate(t *testing.T) {
+	for _, f := range []Format{{Comma: '"'}, {Comma: '\n'}, {Quote: QuoteNever, Comma: '\\'}, {Quote: 7}} {
+		if f.Validate() == nil {
+			t.Errorf("%+v: invalid format accepted", f)
+		}
+	}
+	if _, err := ParseQuotePolicy("sometimes"); err == nil {
+		t.Error("unknown quote policy accepted")
+	}
+}
@@ -0,0 +1,197 @@
+package idfactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FS is a writable file system to which output files are written. Files are
+// staged while they are written and become visible only when committed, so
+// that a failed run leaves no partial output.
+type FS interface {
+	// Create stages a new file with the given name.
+	Create(name string) (StagedFile, error)
+	// Exists reports whether a visible file with the given name exists.
+	Exists(name string) (bool, error)
+	// Open opens a visible file with the given name for reading. The error
+	// wraps os.ErrNotExist if there is no such file.
+	Open(name string) (io.ReadCloser, error)
+}
+
+// ContextFS is implemented by file systems whose staged files can stop waiting
+// on slow storage, such as a stalled upload, when a context is done.
+type ContextFS interface {
+	FS
+	// CreateContext is like Create but the writes to the file give up with
+	// an error once ctx is done.
+	CreateContext(ctx context.Context, name string) (StagedFile, error)
+}
+
+// create stages the named file in fs, bounding its writes by ctx if fs is a
+// ContextFS
+func create(ctx context.Context, fs FS, name string) (StagedFile, error) {
+	if cfs, ok := fs.(ContextFS); ok {
+		return cfs.CreateContext(ctx, name)
+	}
+	return fs.Create(name)
+}
+
+// StagedFile is an output file that is not visible until it is committed.
+type StagedFile interface {
+	io.WriteCloser
+	// Commit makes the closed file visible under its name, replacing any
+	// existing file with the same name.
+	Commit() error
+	// Discard removes the file. If it was committed then the file it
+	// replaced, if any, is restored where the file system allows it.
+	Discard() error
+	// Release frees anything kept to support discarding a committed file.
+	Release() error
+}
+
+//------------------------------------------------------------------------------
+// Local file system
+//------------------------------------------------------------------------------
+
+// DirFS is an FS rooted at a local directory. Files are staged under
+// temporary names in their destination directory and renamed into place when
+// committed. Missing directories are created with DirPerm.
+type DirFS struct {
+	// Dir is the directory against which relative names are resolved. If
+	// empty, the current directory is used.
+	Dir string
+	// Perm is the permission of created files. If zero, DefaultPerm is used.
+	Perm os.FileMode
+}
+
+// path returns the local path of the named file
+func (d DirFS) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(d.Dir, name)
+}
+
+// Exists reports whether the named file exists.
+func (d DirFS) Exists(name string) (bool, error) {
+	_, err := os.Lstat(d.path(name))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Open opens the named file for reading.
+func (d DirFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+// Create stages a new file with the given name.
+func (d DirFS) Create(name string) (StagedFile, error) {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), DirPerm); err != nil {
+		return nil, fmt.Errorf(`idfactor: error creating directory for "%s": %w`, path, err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf(`idfactor: error creating file "%s": %w`, path, err)
+	}
+	perm := d.Perm
+	if perm == 0 {
+		perm = DefaultPerm
+	}
+	// set the exact permission regardless of the umask
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf(`idfactor: error setting permissions of "%s": %w`, path, err)
+	}
+	return &localFile{name: path, tmp: file.Name(), file: file}, nil
+}
+
+// localFile is a file staged under a temporary name
+type localFile struct {
+	name      string
+	tmp       string
+	backup    string
+	file      *os.File
+	committed bool
+}
+
+func (f *localFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	return f.file.Write(p)
+}
+
+// Close closes the staged file. It may be called more than once.
+func (f *localFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Commit renames the staged file into place, first moving any existing file
+// aside so that it can be restored by Discard.
+func (f *localFile) Commit() error {
+	if f.file != nil {
+		return fmt.Errorf(`idfactor: error committing file "%s": file not closed`, f.name)
+	}
+	if f.committed {
+		return nil
+	}
+	if _, err := os.Lstat(f.name); err == nil {
+		f.backup = f.tmp + ".bak"
+		if err := os.Rename(f.name, f.backup); err != nil {
+			f.backup = ""
+			return fmt.Errorf(`idfactor: error replacing file "%s": %w`, f.name, err)
+		}
+	}
+	if err := os.Rename(f.tmp, f.name); err != nil {
+		if f.backup != "" {
+			os.Rename(f.backup, f.name)
+			f.backup = ""
+		}
+		return fmt.Errorf(`idfactor: error committing file "%s": %w`, f.name, err)
+	}
+	f.committed = true
+	return nil
+}
+
+// Discard securely removes the file and restores any file it replaced.
+func (f *localFile) Discard() error {
+	f.Close()
+	if !f.committed {
+		return SecureRemove(f.tmp)
+	}
+	err := SecureRemove(f.name)
+	if f.backup != "" {
+		if rerr := os.Rename(f.backup, f.name); rerr != nil && err == nil {
+			err = rerr
+		}
+		f.backup = ""
+	}
+	f.committed = false
+	return err
+}
+
+// Release securely removes the file replaced by Commit.
+func (f *localFile) Release() error {
+	if f.backup == "" {
+		return nil
+	}
+	err := SecureRemove(f.backup)
+	f.backup = ""
+	return err
+}
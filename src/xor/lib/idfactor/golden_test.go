@@ -0,0 +1,73 @@
+package idfactor_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xor/lib/csprng"
+	"xor/lib/idfactor"
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+	"xor/lib/synth"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestGolden checks seeded factoring output against golden files in
+// testdata. Run with -update to regenerate them after an intended change.
+func TestGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cfg      synth.Config
+		elements []idfactor.Element
+	}{
+		{"atrisk", synth.Config{EmptyRate: 0.1, DuplicateRate: 0.1, Seed: 1}, atrisk.Elements},
+		{"compromised", synth.Config{Compromised: true, Breaches: 3, EmptyRate: 0.1, DuplicateRate: 0.1, Seed: 1}, compromised.Elements},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recs := synth.New(tc.cfg).Records(20)
+			bufs := make([]bytes.Buffer, len(tc.elements))
+			factorers := make([]idfactor.Factorer, len(tc.elements))
+			for i, e := range tc.elements {
+				i, e := i, e
+				config := &idfactor.Config{Rand: csprng.NewInsecureStream(1, e.Name)}
+				factorers[i] = func(recs [][]string) map[string]string {
+					return config.WriteToWriter(recs, &bufs[i], e.Header, e.Get)
+				}
+			}
+			ids, err := idfactor.IDFactor(recs, factorers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m bytes.Buffer
+			idfactor.WriteMapToWriter(ids, &m)
+
+			check(t, filepath.Join("testdata", tc.name, "map.golden"), m.Bytes())
+			for i, e := range tc.elements {
+				check(t, filepath.Join("testdata", tc.name, e.Name+".golden"), bufs[i].Bytes())
+			}
+		})
+	}
+}
+
+func check(t *testing.T, golden string, got []byte) {
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: output differs from golden file\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
@@ -1,15 +1,15 @@
 package idfactor
 
 import (
-	"encoding/csv"
+	"context"
+	"fmt"
 	"io"
 	"log"
-	"os"
-	"runtime"
 	"sync"
 
+	"xor/lib/csprng"
+	"xor/lib/ids"
 	"xor/lib/shuffle"
-	"xor/lib/uuid"
 )
 
 const recordIDField = 0
@@ -18,34 +18,113 @@ const recordIDField = 0
 // These functions write out an element ID map to a file or io.Writer.
 //------------------------------------------------------------------------------
 
+// MapHeader is the column header of an identity map. It names the element id
+// column of each element type in identity map column order.
+var MapHeader = []string{"record_id", "name_id", "ssn_id", "address_id", "phone_id", "email_id", "name_address_id", "name_phone_id", "username_id"}
+
 // WriteMapToFile writes an element id map to the file with the given name.
 func WriteMapToFile(ids [][]string, name string) {
-	file, err := os.Create(name)
-	if err != nil {
-		log.Fatalf(`idfactor: error creating file "%s": %s`, name, err)
-	}
-	WriteMapToWriter(ids, file)
-	if err := file.Close(); err != nil {
-		log.Fatalf(`idfactor: error closing file "%s": %s`, name, err)
+	if err := (&Config{}).WriteMapToFileContext(context.Background(), ids, name); err != nil {
+		log.Fatal(err)
 	}
 }
 
 // WriteMapToWriter writers an element id map to the given io.Writer.
 func WriteMapToWriter(ids [][]string, w io.Writer) {
-	writer := csv.NewWriter(w)
-	writer.Comma = '|'
-	// line terminator
-	if runtime.GOOS == "windows" {
-		writer.UseCRLF = true
+	if err := WriteMapToWriterContext(context.Background(), ids, w); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// WriteMapToFileContext writes an element id map to the file with the given
+// name. It stops and returns an error if ctx is done first.
+func (c *Config) WriteMapToFileContext(ctx context.Context, ids [][]string, name string) error {
+	return c.writeFile(ctx, name, func(w io.Writer) error {
+		return c.WriteMapToWriterContext(ctx, ids, w)
+	})
+}
+
+// WriteMapToWriterContext calls Config.WriteMapToWriterContext on the zero
+// Config.
+func WriteMapToWriterContext(ctx context.Context, ids [][]string, w io.Writer) error {
+	return (&Config{}).WriteMapToWriterContext(ctx, ids, w)
+}
+
+// WriteMapToWriterContext writes an element id map to the given io.Writer. It
+// stops and returns an error if ctx is done first.
+func (c *Config) WriteMapToWriterContext(ctx context.Context, ids [][]string, w io.Writer) error {
+	return c.writeRows(ctx, w, MapHeader, ids, "map")
+}
+
+// WriteElementMapsContext writes one narrow identity map per element type,
+// mapping record ids to the element ids of that type only, so that access to
+// each can be granted independently. elements lists the element types in
+// identity map column order and name gives the file name of each map. It
+// stops and returns an error if ctx is done first.
+func (c *Config) WriteElementMapsContext(ctx context.Context, ids [][]string, elements []Element, name func(e Element) string) error {
+	for i, e := range elements {
+		err := c.writeFile(ctx, name(e), func(w io.Writer) error {
+			return c.WriteElementMapToWriterContext(ctx, ids, i, e, w)
+		})
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// WriteElementMapToWriterContext calls Config.WriteElementMapToWriterContext
+// on the zero Config.
+func WriteElementMapToWriterContext(ctx context.Context, ids [][]string, i int, e Element, w io.Writer) error {
+	return (&Config{}).WriteElementMapToWriterContext(ctx, ids, i, e, w)
+}
+
+// WriteElementMapToWriterContext writes the narrow identity map of the element
+// type at position i in identity map column order to the given io.Writer.
+// Records without an element of that type are left out. It stops and returns
+// an error if ctx is done first.
+func (c *Config) WriteElementMapToWriterContext(ctx context.Context, ids [][]string, i int, e Element, w io.Writer) error {
+	rows := make([][]string, 0, len(ids))
+	for _, row := range ids {
+		if row[1+i] != "" {
+			rows = append(rows, []string{row[0], row[1+i]})
+		}
+	}
+	return c.writeRows(ctx, w, []string{MapHeader[0], e.ID}, rows, "map")
+}
+
+// WriteRowsToFileContext writes rows under the given header to the file with
+// the given name, for files that accompany the elements such as metadata. It
+// stops and returns an error if ctx is done first.
+func (c *Config) WriteRowsToFileContext(ctx context.Context, header []string, rows [][]string, name string) error {
+	return c.writeFile(ctx, name, func(w io.Writer) error {
+		return c.writeRows(ctx, w, header, rows, name)
+	})
+}
+
+// writeRows writes rows under the given header to w in the configured
+// format. what names the rows in the error returned if ctx is done first.
+func (c *Config) writeRows(ctx context.Context, w io.Writer, header []string, rows [][]string, what string) error {
+	writer := c.Format.NewWriter(w)
 	// write file header
-	var mapHeader = []string{"record_id", "name_id", "ssn_id", "address_id", "phone_id", "email_id", "name_address_id", "name_phone_id"}
-	if err := writer.Write(mapHeader); err != nil {
-		log.Fatalf("idfactor: error writing file: %s", err)
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("idfactor: error writing file: %w", err)
 	}
-	if err := writer.WriteAll(ids); err != nil {
-		log.Fatalf("idfactor: error writing file: %s", err)
+	for n, row := range rows {
+		if n%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("idfactor: writing %s stopped: %w", what, err)
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("idfactor: error writing file: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("idfactor: error writing file: %w", err)
 	}
+	return nil
 }
 
 //------------------------------------------------------------------------------
@@ -57,47 +136,172 @@ func WriteMapToWriter(ids [][]string, w io.Writer) {
 // identity element from a full identity record.
 type ElementGetter func(rec []string, id string) []string
 
+// Element describes a type of identity element.
+type Element struct {
+	// Name is the name of the element type, e.g. "ssn"
+	Name string
+	// ID is the column header of the element id, e.g. "ssn_id"
+	ID string
+	// Header is the column header of the element file
+	Header []string
+	// Get extracts the element from a full identity record
+	Get ElementGetter
+}
+
+// Config holds the settings used to write identity elements. The zero Config
+// writes elements in an unpredictable order with random element ids.
+type Config struct {
+	// Rand is the source of randomness used to shuffle elements and generate
+	// element ids. If nil, a new csprng.Stream is used for each call. Since
+	// a Source need not be safe for concurrent use, concurrent factorers
+	// should each be given their own Config.
+	Rand csprng.Source
+	// IDs generates element ids. If nil, random version 4 UUIDs are used.
+	IDs ids.Generator
+	// FS is the file system to which output files are written. If nil,
+	// files are written relative to the current directory.
+	FS FS
+	// Tx, if not nil, is the transaction in which output files are created.
+	// Files then appear only when the transaction is committed. Otherwise
+	// each file appears once it is completely written.
+	Tx *Transaction
+	// Format is the format of output files
+	Format Format
+	// Append adds the rows of each output file to those of an existing file
+	// with the same name, which must have the same header, instead of
+	// creating a new file. The combined rows are shuffled together, and the
+	// combined file replaces the existing one, so existing files must be
+	// allowed to be replaced.
+	Append bool
+}
+
+// source returns the configured source of randomness or a new secure one
+func (c *Config) source() csprng.Source {
+	if c.Rand == nil {
+		return csprng.NewStream()
+	}
+	return c.Rand
+}
+
+// generator returns the configured element id generator or the default one
+func (c *Config) generator() ids.Generator {
+	if c.IDs == nil {
+		return ids.UUIDv4{}
+	}
+	return c.IDs
+}
+
+// Factorer returns a Factorer that writes elements of the given type to the
+// named file.
+func (c *Config) Factorer(e Element, name string) Factorer {
+	return func(recs [][]string) map[string]string {
+		return c.WriteToFile(recs, name, e.Header, e.Get)
+	}
+}
+
+// writeFile creates the named output file and writes its contents with write.
+// Writes to a ContextFS give up once ctx is done.
+func (c *Config) writeFile(ctx context.Context, name string, write func(w io.Writer) error) error {
+	return c.writeSortedFile(ctx, name, nil, write)
+}
+
+// writeSortedFile is like writeFile but in append mode the rows of the file
+// are sorted with cmp, if it is not nil, rather than shuffled.
+func (c *Config) writeSortedFile(ctx context.Context, name string, cmp func(a, b []string) int, write func(w io.Writer) error) error {
+	fs := c.FS
+	if fs == nil {
+		fs = DirFS{}
+	}
+	if c.Append {
+		write = c.appendTo(fs, name, cmp, write)
+	}
+	if c.Tx != nil {
+		return createInTx(ctx, c.Tx, fs, name, write)
+	}
+	return createStaged(ctx, fs, name, write)
+}
+
 // WriteToFile extracts identity elements from a list of full identity records
 // and writes them to the named file. It returns a map from record ids to
 // element ids.
 func WriteToFile(recs [][]string, name string, header []string, get ElementGetter) map[string]string {
-	file, err := os.Create(name)
+	return (&Config{}).WriteToFile(recs, name, header, get)
+}
+
+// WriteToWriter extracts identity elements from a list of full identity records
+// and writes them to the given io.Writer. It returns a map from record ids to
+// element ids.
+func WriteToWriter(recs [][]string, w io.Writer, header []string, get ElementGetter) map[string]string {
+	return (&Config{}).WriteToWriter(recs, w, header, get)
+}
+
+// WriteToFile extracts identity elements from a list of full identity records
+// and writes them to the named file. It returns a map from record ids to
+// element ids.
+func (c *Config) WriteToFile(recs [][]string, name string, header []string, get ElementGetter) map[string]string {
+	idmap, err := c.WriteToFileContext(context.Background(), recs, name, header, get)
 	if err != nil {
-		log.Fatalf(`idfactor: error creating file "%s": %s`, name, err)
+		log.Fatal(err)
 	}
-	result := WriteToWriter(recs, file, header, get)
-	if err := file.Close(); err != nil {
-		log.Fatalf(`idfactor: error closing file "%s": %s`, name, err)
-	}
-	return result
+	return idmap
 }
 
 // WriteToWriter extracts identity elements from a list of full identity records
 // and writes them to the given io.Writer. It returns a map from record ids to
 // element ids.
-func WriteToWriter(recs [][]string, w io.Writer, header []string, get ElementGetter) map[string]string {
-	writer := csv.NewWriter(w)
-	writer.Comma = '|'
-	// line terminator
-	if runtime.GOOS == "windows" {
-		writer.UseCRLF = true
+func (c *Config) WriteToWriter(recs [][]string, w io.Writer, header []string, get ElementGetter) map[string]string {
+	idmap, err := c.WriteToWriterContext(context.Background(), recs, w, header, get)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return idmap
+}
+
+// number of elements written between checks for cancellation
+const checkInterval = 1024
+
+// WriteToFileContext is like WriteToFile but stops writing and returns an
+// error if ctx is done before all elements are written. Any error discards the
+// partially written file, or leaves it to be discarded when the transaction is
+// rolled back.
+func (c *Config) WriteToFileContext(ctx context.Context, recs [][]string, name string, header []string, get ElementGetter) (map[string]string, error) {
+	var idmap map[string]string
+	err := c.writeFile(ctx, name, func(w io.Writer) (err error) {
+		idmap, err = c.WriteToWriterContext(ctx, recs, w, header, get)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return idmap, nil
+}
+
+// WriteToWriterContext is like WriteToWriter but stops writing and returns an
+// error if ctx is done before all elements are written.
+func (c *Config) WriteToWriterContext(ctx context.Context, recs [][]string, w io.Writer, header []string, get ElementGetter) (map[string]string, error) {
+	writer := c.Format.NewWriter(w)
 	// write file header
 	if err := writer.Write(header); err != nil {
-		log.Fatalf("idfactor: error writing file: %s", err)
+		return nil, fmt.Errorf("idfactor: error writing file: %w", err)
 	}
 
 	// map record ids to element ids
 	idmap := make(map[string]string)
 	// write elements in shuffled order
-	for _, i := range shuffle.Shuffle(len(recs)) {
-		// generate a new uuid for element id
-		elemid := uuid.New()
+	src, gen := c.source(), c.generator()
+	for n, i := range shuffle.New(src).Shuffle(len(recs)) {
+		if n%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("idfactor: writing elements stopped: %w", err)
+			}
+		}
+		// generate a new element id
+		elemid := gen.New(src)
 		id := recs[i][recordIDField]
 		// only write non-nil elements
 		if elem := get(recs[i], elemid); elem != nil {
 			if err := writer.Write(elem); err != nil {
-				log.Fatalf(`idfactor: error writing element: %s`, err)
+				return nil, fmt.Errorf("idfactor: error writing element: %w", err)
 			}
 			// update id mapping
 			idmap[id] = elemid
@@ -105,13 +309,13 @@ func WriteToWriter(recs [][]string, w io.Writer, header []string, get ElementGet
 	}
 	writer.Flush()
 	if err := writer.Error(); err != nil {
-		log.Fatalf(`idfactor: error flushing writer: %s`, err)
+		return nil, fmt.Errorf("idfactor: error flushing writer: %w", err)
 	}
-	return idmap
+	return idmap, nil
 }
 
 //------------------------------------------------------------------------------
-//  This function applies a list of functions to a list of records.
+//  These functions apply a list of functions to a list of records.
 //------------------------------------------------------------------------------
 
 type Factorer func(recs [][]string) map[string]string
@@ -131,18 +335,85 @@ func IDFactor(recs [][]string, factorers ...Factorer) ([][]string, error) {
 	}
 	workers.Wait()
 
-	// construct id map
+	return buildMap(recs, idMaps), nil
+}
+
+// FactorerContext is a Factorer that stops and returns an error when its
+// context is done.
+type FactorerContext func(ctx context.Context, recs [][]string) (map[string]string, error)
+
+// FactorerContext returns a FactorerContext that writes elements of the given
+// type to the named file.
+func (c *Config) FactorerContext(e Element, name string) FactorerContext {
+	return func(ctx context.Context, recs [][]string) (map[string]string, error) {
+		return c.WriteToFileContext(ctx, recs, name, e.Header, e.Get)
+	}
+}
+
+// IDFactorContext is like IDFactor but runs factorers that accept a context.
+// If ctx is done or any factorer fails then the remaining factorers are
+// cancelled and the first error is returned once every factorer has stopped,
+// so that their files can then be safely discarded.
+func IDFactorContext(ctx context.Context, recs [][]string, factorers ...FactorerContext) ([][]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// concurrent factoring
+	type result struct {
+		i     int
+		idmap map[string]string
+		err   error
+	}
+	n := len(factorers)
+	results := make(chan result, n)
+	for i, factor := range factorers {
+		go func(i int, factor FactorerContext) {
+			idmap, err := factor(ctx, recs)
+			results <- result{i, idmap, err}
+		}(i, factor)
+	}
+
+	// collect results until all factorers finish, cancelling the rest once
+	// one fails
+	idMaps := make([]map[string]string, n)
+	var err error
+	done := ctx.Done()
+	for remaining := n; remaining > 0; {
+		select {
+		case r := <-results:
+			remaining--
+			if r.err != nil && err == nil {
+				err = r.err
+				cancel()
+			}
+			idMaps[r.i] = r.idmap
+		case <-done:
+			done = nil
+			if err == nil {
+				err = ctx.Err()
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("idfactor: factoring failed: %w", err)
+	}
+
+	return buildMap(recs, idMaps), nil
+}
+
+// buildMap constructs an identity map from the record id to element id maps
+// returned by each factorer
+func buildMap(recs [][]string, idMaps []map[string]string) [][]string {
 	ids := make([][]string, len(recs))
 	for i := range recs {
 		recordID := recs[i][recordIDField]
-		ids[i] = make([]string, 1+n)
+		ids[i] = make([]string, 1+len(idMaps))
 		ids[i][0] = recordID
 		for j := range idMaps {
 			ids[i][1+j] = idMaps[j][recordID]
 		}
 	}
-
-	return ids, nil
+	return ids
 }
 
 //------------------------------------------------------------------------------
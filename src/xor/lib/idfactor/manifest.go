@@ -0,0 +1,189 @@
+package idfactor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+//------------------------------------------------------------------------------
+// The manifest summarizes the output of a run so that the recipient can check
+// a delivery, including one that legitimately holds no records.
+//------------------------------------------------------------------------------
+
+// ManifestHeader is the column header of a manifest.
+var ManifestHeader = []string{"file", "element", "rows"}
+
+// ManifestEntry is one row of a manifest: the number of rows in an input or
+// element file.
+type ManifestEntry struct {
+	// File is the name of the file
+	File string
+	// Element is the element type of an element file, or "record" for an
+	// input file
+	Element string
+	// Rows is the number of rows in the file, not counting the header
+	Rows int
+}
+
+// Manifest lists the input and element files of a run with their row counts.
+type Manifest []ManifestEntry
+
+// AllInputs is the file name under which a manifest counts the records of all
+// inputs together.
+const AllInputs = "*"
+
+// Input is an input file of a run and the number of records read from it.
+type Input struct {
+	Name    string
+	Records int
+}
+
+// NewManifest returns the manifest of a run that read records from the given
+// inputs and produced the given identity map. elements lists the element
+// types in identity map column order and file gives the name of the element
+// file of each.
+func NewManifest(inputs []Input, ids [][]string, elements []Element, file func(e Element) string) Manifest {
+	var m Manifest
+	for _, in := range inputs {
+		m = append(m, ManifestEntry{File: in.Name, Element: "record", Rows: in.Records})
+	}
+	for i, e := range elements {
+		n := 0
+		for _, row := range ids {
+			if row[1+i] != "" {
+				n++
+			}
+		}
+		m = append(m, ManifestEntry{File: file(e), Element: e.Name, Rows: n})
+	}
+	return m
+}
+
+// MergeManifests returns the manifest of the combined output of several runs,
+// as written in append mode. The rows of each element file are added up, and
+// the records of all inputs are counted together under AllInputs, so that the
+// manifest does not reveal how many records each run added.
+func MergeManifests(manifests ...Manifest) Manifest {
+	merged := Manifest{{File: AllInputs, Element: "record"}}
+	index := make(map[ManifestEntry]int)
+	for _, m := range manifests {
+		for _, entry := range m {
+			if entry.Element == "record" {
+				merged[0].Rows += entry.Rows
+				continue
+			}
+			key := ManifestEntry{File: entry.File, Element: entry.Element}
+			i, ok := index[key]
+			if !ok {
+				i = len(merged)
+				index[key] = i
+				merged = append(merged, key)
+			}
+			merged[i].Rows += entry.Rows
+		}
+	}
+	return merged
+}
+
+// ReadManifest reads a manifest written in the given format.
+func ReadManifest(r io.Reader, f Format) (Manifest, error) {
+	rows, err := f.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("idfactor: error reading manifest: %w", err)
+	}
+	if len(rows) == 0 || !slices.Equal(rows[0], ManifestHeader) {
+		return nil, fmt.Errorf("idfactor: bad manifest header")
+	}
+	m := make(Manifest, len(rows)-1)
+	for i, row := range rows[1:] {
+		n, err := strconv.Atoi(row[2])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("idfactor: bad row count %q in manifest", row[2])
+		}
+		m[i] = ManifestEntry{File: row[0], Element: row[1], Rows: n}
+	}
+	return m, nil
+}
+
+// Erase returns the manifest of a delivery after an erasure. inputs gives the
+// number of erased records read from each input, by name, with those of an
+// unknown input under "", and rows the number of rows removed from each
+// element file. Erased records of an unknown input, or of one that the
+// manifest does not list, are taken from its only input, or else the records
+// of all inputs are counted together under AllInputs. The manifest is not
+// modified.
+func (m Manifest) Erase(inputs, rows map[string]int) (Manifest, error) {
+	listed := make(map[string]bool)
+	for _, entry := range m {
+		if entry.Element == "record" {
+			listed[entry.File] = true
+		}
+	}
+	unknown, total := 0, 0
+	for name, n := range inputs {
+		if !listed[name] {
+			unknown += n
+		}
+		total += n
+	}
+	collapse := unknown > 0 && len(listed) > 1
+	erased := make(Manifest, 0, len(m))
+	if collapse {
+		erased = append(erased, ManifestEntry{File: AllInputs, Element: "record", Rows: -total})
+	}
+	for _, entry := range m {
+		switch {
+		case entry.Element == "record" && collapse:
+			erased[0].Rows += entry.Rows
+			continue
+		case entry.Element == "record" && len(listed) == 1:
+			entry.Rows -= total
+		case entry.Element == "record":
+			entry.Rows -= inputs[entry.File]
+		default:
+			entry.Rows -= rows[entry.File]
+		}
+		if entry.Rows < 0 {
+			return nil, fmt.Errorf("idfactor: more rows erased than the manifest states for %s", entry.File)
+		}
+		erased = append(erased, entry)
+	}
+	if collapse && erased[0].Rows < 0 {
+		return nil, fmt.Errorf("idfactor: more records erased than the manifest states")
+	}
+	return erased, nil
+}
+
+// Records returns the total number of input records in the manifest.
+func (m Manifest) Records() int {
+	n := 0
+	for _, entry := range m {
+		if entry.Element == "record" {
+			n += entry.Rows
+		}
+	}
+	return n
+}
+
+// WriteManifestToFileContext writes a manifest to the file with the given
+// name. It stops and returns an error if ctx is done first.
+func (c *Config) WriteManifestToFileContext(ctx context.Context, m Manifest, name string) error {
+	return c.WriteRowsToFileContext(ctx, ManifestHeader, m.rows(), name)
+}
+
+// WriteManifestToWriter writes a manifest to the given io.Writer.
+func (c *Config) WriteManifestToWriter(m Manifest, w io.Writer) error {
+	return c.writeRows(context.Background(), w, ManifestHeader, m.rows(), "manifest")
+}
+
+// rows returns the rows of the manifest
+func (m Manifest) rows() [][]string {
+	rows := make([][]string, len(m))
+	for i, entry := range m {
+		rows[i] = []string{entry.File, entry.Element, strconv.Itoa(entry.Rows)}
+	}
+	return rows
+}
@@ -0,0 +1,122 @@
+package idfactor_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"xor/lib/idfactor"
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+)
+
+// TestEmptyInput checks that factoring no records writes header only element
+// files, an empty map and a manifest stating zero records.
+func TestEmptyInput(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		elements []idfactor.Element
+	}{
+		{"atrisk", atrisk.Elements},
+		{"compromised", compromised.Elements},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tx := &idfactor.Transaction{}
+			config := &idfactor.Config{FS: idfactor.DirFS{Dir: dir}, Tx: tx}
+			file := func(e idfactor.Element) string { return e.Name + ".psv" }
+			factorers := make([]idfactor.FactorerContext, len(tc.elements))
+			for i, e := range tc.elements {
+				factorers[i] = config.FactorerContext(e, file(e))
+			}
+			ctx := context.Background()
+			ids, err := idfactor.IDFactorContext(ctx, nil, factorers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := config.WriteMapToFileContext(ctx, ids, "map.psv"); err != nil {
+				t.Fatal(err)
+			}
+			manifest := idfactor.NewManifest([]idfactor.Input{{Name: "input.psv"}}, ids, tc.elements, file)
+			if err := config.WriteManifestToFileContext(ctx, manifest, "manifest.psv"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, e := range tc.elements {
+				checkFile(t, filepath.Join(dir, file(e)), strings.Join(e.Header, "|")+"\n")
+			}
+			checkFile(t, filepath.Join(dir, "map.psv"), strings.Join(idfactor.MapHeader, "|")+"\n")
+			want := "file|element|rows\ninput.psv|record|0\n"
+			for _, e := range tc.elements {
+				want += file(e) + "|" + e.Name + "|0\n"
+			}
+			checkFile(t, filepath.Join(dir, "manifest.psv"), want)
+			if n := manifest.Records(); n != 0 {
+				t.Errorf("manifest states %d records, want 0", n)
+			}
+		})
+	}
+}
+
+func checkFile(t *testing.T, name, want string) {
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(name), got, want)
+	}
+}
+
+func TestManifestErase(t *testing.T) {
+	m := idfactor.Manifest{
+		{File: "a.psv", Element: "record", Rows: 3},
+		{File: "b.psv", Element: "record", Rows: 2},
+		{File: "ssn.psv", Element: "ssn", Rows: 5},
+		{File: "email.psv", Element: "email", Rows: 4},
+	}
+	rows := map[string]int{"ssn.psv": 2, "email.psv": 1}
+	for _, tc := range []struct {
+		name   string
+		inputs map[string]int
+		want   idfactor.Manifest
+	}{
+		{"known inputs", map[string]int{"a.psv": 1, "b.psv": 1}, idfactor.Manifest{
+			{File: "a.psv", Element: "record", Rows: 2},
+			{File: "b.psv", Element: "record", Rows: 1},
+			{File: "ssn.psv", Element: "ssn", Rows: 3},
+			{File: "email.psv", Element: "email", Rows: 3},
+		}},
+		{"unknown input", map[string]int{"": 1, "a.psv": 1}, idfactor.Manifest{
+			{File: idfactor.AllInputs, Element: "record", Rows: 3},
+			{File: "ssn.psv", Element: "ssn", Rows: 3},
+			{File: "email.psv", Element: "email", Rows: 3},
+		}},
+	} {
+		got, err := m.Erase(tc.inputs, rows)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if m[0].Rows != 3 {
+		t.Error("manifest was modified")
+	}
+
+	// the only input takes the erased records of an unknown input
+	got, err := m[1:].Erase(map[string]int{"": 2}, nil)
+	if err != nil || got[0].Rows != 0 {
+		t.Errorf("single input: got %v, %v", got, err)
+	}
+	if _, err := m.Erase(map[string]int{"b.psv": 3}, nil); err == nil {
+		t.Error("erased more records than the manifest states")
+	}
+}
@@ -0,0 +1,60 @@
+package idfactor
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+// Provenance of records read from several input files. It maps each record id
+// in the identity map to the input file the record was read from.
+//------------------------------------------------------------------------------
+
+// ProvenanceHeader is the column header of a provenance file.
+var ProvenanceHeader = []string{"record_id", "source"}
+
+// Provenance returns the provenance of records, where sources gives the input
+// file of each record.
+func Provenance(recs [][]string, sources []string) [][]string {
+	provenance := make([][]string, len(recs))
+	for i, rec := range recs {
+		provenance[i] = []string{rec[recordIDField], sources[i]}
+	}
+	return provenance
+}
+
+// PseudonymizeProvenance replaces the record ids of a provenance with their
+// surrogates from a record id crosswalk, so that it joins with the
+// pseudonymized identity map. The rows are sorted by surrogate, so that their
+// order does not reveal that of the record ids. The given provenance is not
+// modified.
+func PseudonymizeProvenance(provenance, crosswalk [][]string) [][]string {
+	surrogates := make(map[string]string, len(crosswalk))
+	for _, row := range crosswalk {
+		surrogates[row[0]] = row[1]
+	}
+	pseudonymized := make([][]string, len(provenance))
+	for i, row := range provenance {
+		pseudonymized[i] = []string{surrogates[row[0]], row[1]}
+	}
+	slices.SortFunc(pseudonymized, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	return pseudonymized
+}
+
+// WriteProvenanceToFileContext writes a provenance to the file with the given
+// name. It stops and returns an error if ctx is done first.
+func (c *Config) WriteProvenanceToFileContext(ctx context.Context, provenance [][]string, name string) error {
+	return c.writeFile(ctx, name, func(w io.Writer) error {
+		return c.WriteProvenanceToWriterContext(ctx, provenance, w)
+	})
+}
+
+// WriteProvenanceToWriterContext writes a provenance to the given io.Writer.
+// It stops and returns an error if ctx is done first.
+func (c *Config) WriteProvenanceToWriterContext(ctx context.Context, provenance [][]string, w io.Writer) error {
+	return c.writeRows(ctx, w, ProvenanceHeader, provenance, "provenance")
+}
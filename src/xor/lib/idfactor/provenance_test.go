@@ -0,0 +1,33 @@
+package idfactor_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"xor/lib/idfactor"
+)
+
+func TestProvenance(t *testing.T) {
+	recs := [][]string{{"r1", "a"}, {"r2", "b"}, {"r3", "c"}}
+	provenance := idfactor.Provenance(recs, []string{"x.psv", "x.psv", "y.psv"})
+	crosswalk := [][]string{{"r3", "s1"}, {"r1", "s3"}, {"r2", "s2"}}
+	got := idfactor.PseudonymizeProvenance(provenance, crosswalk)
+	want := [][]string{{"s1", "y.psv"}, {"s2", "x.psv"}, {"s3", "x.psv"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("provenance = %v, want %v", got, want)
+	}
+	if provenance[0][0] != "r1" {
+		t.Error("provenance was modified")
+	}
+
+	var buf bytes.Buffer
+	config := &idfactor.Config{}
+	if err := config.WriteProvenanceToWriterContext(context.Background(), provenance, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "record_id|source\nr1|x.psv\nr2|x.psv\nr3|y.psv\n"; buf.String() != want {
+		t.Errorf("provenance file = %q, want %q", buf.String(), want)
+	}
+}
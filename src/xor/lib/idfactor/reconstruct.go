@@ -0,0 +1,169 @@
+package idfactor
+
+import (
+	"fmt"
+	"io"
+)
+
+//------------------------------------------------------------------------------
+// Reconstruction of identity records from identity maps and element files
+//------------------------------------------------------------------------------
+
+// IdentityMap maps record ids to element ids. It is built from any number of
+// identity maps, either the full map or the narrow per element type maps, so
+// that any subset of the maps can be used to re-link the elements it covers.
+type IdentityMap struct {
+	// RecordIDs lists the record ids in the order they were first read
+	RecordIDs []string
+	// IDs maps record ids to element ids by element id column, e.g. "ssn_id"
+	IDs map[string]map[string]string
+	// Columns holds the element id columns of the maps read, whether or not
+	// they hold any element ids
+	Columns map[string]bool
+	// Format is the format of the maps read
+	Format Format
+}
+
+// NewIdentityMap returns an empty IdentityMap.
+func NewIdentityMap() *IdentityMap {
+	return &IdentityMap{IDs: make(map[string]map[string]string), Columns: make(map[string]bool)}
+}
+
+// Read merges the identity map read from r. The map has a record_id column
+// followed by one or more element id columns. It is an error for a record to
+// be mapped to two different element ids of the same type.
+func (m *IdentityMap) Read(r io.Reader) error {
+	rows, err := m.Format.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("idfactor: error reading identity map: %w", err)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("idfactor: empty identity map")
+	}
+	header := rows[0]
+	if len(header) < 2 || header[0] != MapHeader[0] {
+		return fmt.Errorf("idfactor: bad identity map header %v", header)
+	}
+	for _, col := range header[1:] {
+		m.Columns[col] = true
+	}
+	for _, row := range rows[1:] {
+		recordID := row[0]
+		ids, ok := m.IDs[recordID]
+		if !ok {
+			ids = make(map[string]string)
+			m.IDs[recordID] = ids
+			m.RecordIDs = append(m.RecordIDs, recordID)
+		}
+		for i, col := range header[1:] {
+			id := row[1+i]
+			if id == "" {
+				continue
+			}
+			if prev, ok := ids[col]; ok && prev != id {
+				return fmt.Errorf("idfactor: record %s has conflicting %s values %s and %s", recordID, col, prev, id)
+			}
+			ids[col] = id
+		}
+	}
+	return nil
+}
+
+// Has reports whether the map holds any element ids of the given type.
+func (m *IdentityMap) Has(e Element) bool {
+	for _, ids := range m.IDs {
+		if ids[e.ID] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Reused returns an id of the identity map ids, either a record id or an
+// element id, that is already in m, and false if there is none.
+func (m *IdentityMap) Reused(ids [][]string) (string, bool) {
+	used := make(map[string]bool)
+	for recordID, elementIDs := range m.IDs {
+		used[recordID] = true
+		for _, id := range elementIDs {
+			used[id] = true
+		}
+	}
+	for _, row := range ids {
+		for _, id := range row {
+			if id != "" && used[id] {
+				return id, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Reconstruct rebuilds the identity records in the map from element files.
+// header is the column header of the input records, elements lists the
+// element types, and files holds the rows, including the header, of the
+// element file of each element type by name. Each record field is taken from
+// the first element that has a column of the same name, so the fields not
+// covered by the available maps and element files are left empty.
+func (m *IdentityMap) Reconstruct(header []string, elements []Element, files map[string][][]string) ([][]string, error) {
+	fields := make(map[string]int, len(header))
+	for i, h := range header {
+		fields[h] = i
+	}
+
+	// index the element rows by element id
+	type elementRows struct {
+		e      Element
+		header []string
+		rows   map[string][]string
+	}
+	var available []elementRows
+	for _, e := range elements {
+		file, ok := files[e.Name]
+		if !ok || !m.Has(e) {
+			continue
+		}
+		if len(file) == 0 {
+			return nil, fmt.Errorf("idfactor: %s elements: missing header", e.Name)
+		}
+		col := -1
+		for i, h := range file[0] {
+			if h == e.ID {
+				col = i
+			}
+		}
+		if col < 0 {
+			return nil, fmt.Errorf("idfactor: %s elements: no %s column", e.Name, e.ID)
+		}
+		rows := make(map[string][]string, len(file)-1)
+		for _, row := range file[1:] {
+			rows[row[col]] = row
+		}
+		available = append(available, elementRows{e, file[0], rows})
+	}
+
+	recs := make([][]string, len(m.RecordIDs))
+	for n, recordID := range m.RecordIDs {
+		rec := make([]string, len(header))
+		rec[recordIDField] = recordID
+		set := make([]bool, len(header))
+		for _, a := range available {
+			id := m.IDs[recordID][a.e.ID]
+			if id == "" {
+				continue
+			}
+			row, ok := a.rows[id]
+			if !ok {
+				return nil, fmt.Errorf("idfactor: %s elements: element id %s of record %s not found", a.e.Name, id, recordID)
+			}
+			for i, h := range a.header {
+				if j, ok := fields[h]; ok && !set[j] {
+					rec[j] = row[i]
+					set[j] = true
+				}
+			}
+		}
+		recs[n] = rec
+	}
+	return recs, nil
+}
@@ -0,0 +1,207 @@
+package idfactor_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"xor/lib/idfactor"
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+	"xor/lib/synth"
+)
+
+// factorElements factors recs with the given element types and returns the
+// identity map and the parsed element files by element type name.
+func factorElements(t *testing.T, recs [][]string, elements []idfactor.Element) ([][]string, map[string][][]string) {
+	bufs := make([]bytes.Buffer, len(elements))
+	factorers := make([]idfactor.Factorer, len(elements))
+	for i, e := range elements {
+		i, e := i, e
+		factorers[i] = func(recs [][]string) map[string]string {
+			return idfactor.WriteToWriter(recs, &bufs[i], e.Header, e.Get)
+		}
+	}
+	ids, err := idfactor.IDFactor(recs, factorers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][][]string)
+	for i, e := range elements {
+		reader := csv.NewReader(&bufs[i])
+		reader.Comma = '|'
+		rows, err := reader.ReadAll()
+		if err != nil {
+			t.Fatalf("reading %s elements: %s", e.Name, err)
+		}
+		files[e.Name] = rows
+	}
+	return ids, files
+}
+
+// TestReconstruct checks that records are rebuilt from the full map, from all
+// of the narrow maps, and from any subset of the narrow maps.
+func TestReconstruct(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cfg      synth.Config
+		header   []string
+		elements []idfactor.Element
+	}{
+		{"atrisk", synth.Config{EmptyRate: 0.2, DuplicateRate: 0.2, Seed: 1}, atrisk.Header, atrisk.Elements},
+		{"compromised", synth.Config{Compromised: true, Breaches: 3, EmptyRate: 0.2, DuplicateRate: 0.2, Seed: 1}, compromised.Header, compromised.Elements},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recs := synth.New(tc.cfg).Records(200)
+			ids, files := factorElements(t, recs, tc.elements)
+
+			// full map
+			var full bytes.Buffer
+			idfactor.WriteMapToWriter(ids, &full)
+			m := idfactor.NewIdentityMap()
+			if err := m.Read(&full); err != nil {
+				t.Fatal(err)
+			}
+			got, err := m.Reconstruct(tc.header, tc.elements, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, recs) {
+				t.Errorf("records reconstructed from the full map differ from input")
+			}
+
+			// narrow maps
+			maps := make(map[string][]byte)
+			for i, e := range tc.elements {
+				var buf bytes.Buffer
+				if err := idfactor.WriteElementMapToWriterContext(context.Background(), ids, i, e, &buf); err != nil {
+					t.Fatal(err)
+				}
+				maps[e.Name] = buf.Bytes()
+			}
+			m = idfactor.NewIdentityMap()
+			for _, e := range tc.elements {
+				if err := m.Read(bytes.NewReader(maps[e.Name])); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err = m.Reconstruct(tc.header, tc.elements, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// records are ordered by the map they first appear in, and records
+			// with no elements at all appear in no narrow map
+			n := 0
+			for _, row := range ids {
+				if !idfactor.AllEmpty(row[1:]...) {
+					n++
+				}
+			}
+			if len(got) != n {
+				t.Errorf("%d records reconstructed from the narrow maps, want %d", len(got), n)
+			}
+			for _, rec := range got {
+				if want := recs[indexOf(ids, rec[0])]; !reflect.DeepEqual(rec, want) {
+					t.Fatalf("record reconstructed from the narrow maps is %v, want %v", rec, want)
+				}
+			}
+
+			// ssn and email maps only
+			m = idfactor.NewIdentityMap()
+			for _, name := range []string{"ssn", "email"} {
+				if err := m.Read(bytes.NewReader(maps[name])); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err = m.Reconstruct(tc.header, tc.elements, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			allowed := map[string]bool{"record_id": true, "breach_id": true, "ssn": true, "email": true}
+			for n, rec := range got {
+				want := recs[indexOf(ids, rec[0])]
+				for j, h := range tc.header {
+					if allowed[h] {
+						if rec[j] != want[j] {
+							t.Fatalf("record %d: %s = %q, want %q", n, h, rec[j], want[j])
+						}
+					} else if rec[j] != "" {
+						t.Fatalf("record %d: %s = %q recovered without its map", n, h, rec[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+// indexOf returns the position of the given record id in the identity map.
+func indexOf(ids [][]string, recordID string) int {
+	for i, row := range ids {
+		if row[0] == recordID {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestReadMapConflict checks that merging maps that disagree is an error.
+func TestReadMapConflict(t *testing.T) {
+	m := idfactor.NewIdentityMap()
+	if err := m.Read(bytes.NewBufferString("record_id|ssn_id\nR1|a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Read(bytes.NewBufferString("record_id|ssn_id|email_id\nR1|b|c\n")); err == nil {
+		t.Error("conflicting element ids were merged")
+	}
+	if err := m.Read(bytes.NewBufferString("ssn_id|record_id\n")); err == nil {
+		t.Error("bad header was accepted")
+	}
+}
+
+// TestPseudonymize checks that surrogate ids hide the record ids in the map
+// and that the crosswalk restores them.
+func TestPseudonymize(t *testing.T) {
+	recs := synth.New(synth.Config{Seed: 1}).Records(200)
+	ids, files := factorElements(t, recs, atrisk.Elements)
+	pseudonymized, crosswalk := (&idfactor.Config{}).Pseudonymize(ids)
+
+	var m, x bytes.Buffer
+	idfactor.WriteMapToWriter(pseudonymized, &m)
+	if err := idfactor.WriteCrosswalkToWriterContext(context.Background(), crosswalk, &x); err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if bytes.Contains(m.Bytes(), []byte(rec[0])) {
+			t.Fatalf("record id %s appears in the pseudonymized map", rec[0])
+		}
+	}
+
+	im := idfactor.NewIdentityMap()
+	if err := im.Read(&m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := im.Reconstruct(atrisk.Header, atrisk.Elements, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordIDs, err := idfactor.ReadCrosswalk(&x, idfactor.Format{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inOrder := true
+	for i, rec := range got {
+		rec[0] = recordIDs[rec[0]]
+		inOrder = inOrder && rec[0] == recs[i][0]
+	}
+	if inOrder {
+		t.Error("the pseudonymized map keeps the order of the record ids")
+	}
+	slices.SortFunc(got, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+	if !reflect.DeepEqual(got, recs) {
+		t.Errorf("records reconstructed through the crosswalk differ from input")
+	}
+}
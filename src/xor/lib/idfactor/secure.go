@@ -0,0 +1,57 @@
+package idfactor
+
+import (
+	"io"
+	"os"
+)
+
+// DefaultPerm is the permission of output files unless otherwise configured.
+// Output holds raw identity elements and must not be readable by other users.
+const DefaultPerm os.FileMode = 0600
+
+// DirPerm is the permission of output directories created when missing.
+const DirPerm os.FileMode = 0700
+
+// SecureRemove overwrites the contents of the named file with zeros, flushes
+// it to storage and removes it. It makes a best effort: the file is removed
+// even if it cannot be overwritten. Journaling and copy-on-write file systems
+// and SSD wear leveling may retain copies of the original data.
+func SecureRemove(name string) error {
+	var werr error
+	if file, err := os.OpenFile(name, os.O_WRONLY, 0); err == nil {
+		werr = overwrite(file)
+		if cerr := file.Close(); werr == nil {
+			werr = cerr
+		}
+	}
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return werr
+}
+
+// overwrite replaces the contents of file with zeros and syncs it
+func overwrite(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	zeros := make([]byte, 64*1024)
+	for remaining := info.Size(); remaining > 0; {
+		n := int64(len(zeros))
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := file.Write(zeros[:n]); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return file.Sync()
+}
@@ -0,0 +1,61 @@
+package idfactor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverwrite(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "secret")
+	data := bytes.Repeat([]byte("123-45-6789|"), 10000)
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := overwrite(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) || !bytes.Equal(got, make([]byte, len(data))) {
+		t.Error("file contents not replaced with zeros")
+	}
+	if err := SecureRemove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("file not removed")
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	tx := &Transaction{}
+	for name, fs := range map[string]FS{"a": DirFS{Dir: dir}, "b": DirFS{Dir: dir, Perm: 0640}} {
+		f, err := tx.Create(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{dir: DirPerm, filepath.Join(dir, "a"): DefaultPerm, filepath.Join(dir, "b"): 0640} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s has mode %o, want %o", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package idfactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// Transaction creates a set of output files, possibly in different file
+// systems, that appear all together or not at all. A Transaction is safe for
+// concurrent use.
+type Transaction struct {
+	// Overwrite allows existing files to be replaced. Otherwise Create and
+	// Commit fail if a file with the same name already exists.
+	Overwrite bool
+
+	mu    sync.Mutex
+	files []*txFile
+	done  bool
+}
+
+// txFile is a file staged within a transaction
+type txFile struct {
+	StagedFile
+	fs     FS
+	name   string
+	closed bool
+}
+
+// Close closes the staged file but does not commit it.
+func (f *txFile) Close() error {
+	f.closed = true
+	return f.StagedFile.Close()
+}
+
+// ErrExist is returned when an output file already exists and overwriting
+// was not allowed.
+var ErrExist = errors.New("output file already exists")
+
+// Create stages the named file in fs within the transaction. The file must be
+// closed before the transaction is committed.
+func (t *Transaction) Create(fs FS, name string) (io.WriteCloser, error) {
+	return t.CreateContext(context.Background(), fs, name)
+}
+
+// CreateContext is like Create but if fs is a ContextFS then the writes to the
+// file give up once ctx is done.
+func (t *Transaction) CreateContext(ctx context.Context, fs FS, name string) (io.WriteCloser, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return nil, fmt.Errorf(`idfactor: error creating file "%s": transaction already finished`, name)
+	}
+	for _, f := range t.files {
+		if sameFile(f.fs, f.name, fs, name) {
+			return nil, fmt.Errorf(`idfactor: error creating file "%s": already created in this transaction`, name)
+		}
+	}
+	if err := t.checkExists(fs, name); err != nil {
+		return nil, err
+	}
+	staged, err := create(ctx, fs, name)
+	if err != nil {
+		return nil, err
+	}
+	f := &txFile{StagedFile: staged, fs: fs, name: name}
+	t.files = append(t.files, f)
+	return f, nil
+}
+
+// sameFile reports whether name in fs and other in otherFS are the same file.
+// File systems whose type is not comparable are never the same.
+func sameFile(fs FS, name string, otherFS FS, other string) bool {
+	if d, ok := fs.(DirFS); ok {
+		o, ok := otherFS.(DirFS)
+		return ok && filepath.Clean(d.path(name)) == filepath.Clean(o.path(other))
+	}
+	t := reflect.TypeOf(fs)
+	return name == other && t == reflect.TypeOf(otherFS) && t.Comparable() && fs == otherFS
+}
+
+// checkExists returns ErrExist if name exists and overwriting is not allowed
+func (t *Transaction) checkExists(fs FS, name string) error {
+	if t.Overwrite {
+		return nil
+	}
+	exists, err := fs.Exists(name)
+	if err != nil {
+		return fmt.Errorf(`idfactor: error checking file "%s": %w`, name, err)
+	}
+	if exists {
+		return fmt.Errorf(`idfactor: error creating file "%s": %w`, name, ErrExist)
+	}
+	return nil
+}
+
+// Commit makes every file created in the transaction visible. If any file
+// cannot be committed then the files already committed are discarded, any
+// files they replaced are restored where possible, and the transaction is
+// rolled back.
+func (t *Transaction) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errors.New("idfactor: transaction already finished")
+	}
+
+	// every file must be complete and its destination free
+	var err error
+	for _, f := range t.files {
+		if !f.closed {
+			err = fmt.Errorf(`idfactor: error committing file "%s": file not closed`, f.name)
+			break
+		}
+		if err = t.checkExists(f.fs, f.name); err != nil {
+			break
+		}
+	}
+	for _, f := range t.files {
+		if err != nil {
+			break
+		}
+		err = f.Commit()
+	}
+	if err != nil {
+		t.rollback()
+		return err
+	}
+
+	for _, f := range t.files {
+		f.Release()
+	}
+	t.done = true
+	return nil
+}
+
+// Rollback discards every file created in the transaction. Rolling back a
+// finished transaction has no effect.
+func (t *Transaction) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done {
+		t.rollback()
+	}
+}
+
+func (t *Transaction) rollback() {
+	for _, f := range t.files {
+		f.Discard()
+	}
+	t.done = true
+}
+
+// createStaged writes a file outside of a transaction, committing it if write
+// succeeds and discarding it otherwise
+func createStaged(ctx context.Context, fs FS, name string, write func(w io.Writer) error) error {
+	f, err := create(ctx, fs, name)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf(`idfactor: error closing file "%s": %w`, name, cerr)
+	}
+	if err == nil {
+		err = f.Commit()
+	}
+	if err != nil {
+		f.Discard()
+		return err
+	}
+	f.Release()
+	return nil
+}
+
+// createInTx writes a file within a transaction
+func createInTx(ctx context.Context, tx *Transaction, fs FS, name string, write func(w io.Writer) error) error {
+	f, err := tx.CreateContext(ctx, fs, name)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf(`idfactor: error closing file "%s": %w`, name, cerr)
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package idfactor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, tx *Transaction, name, content string) io.WriteCloser {
+	f, err := tx.Create(DirFS{}, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	tx := &Transaction{}
+	a := writeFile(t, tx, filepath.Join(dir, "a"), "A")
+	b := writeFile(t, tx, filepath.Join(dir, "b"), "B")
+	a.Close()
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit succeeded with an open file")
+	}
+	b.Close()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("failed commit left %d files", len(files))
+	}
+
+	tx = &Transaction{}
+	writeFile(t, tx, filepath.Join(dir, "a"), "A").Close()
+	writeFile(t, tx, filepath.Join(dir, "b"), "B").Close()
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Fatalf("%d files before commit, want 2 temporary files", len(files))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); err == nil {
+		t.Fatal("file visible before commit")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, filepath.Join(dir, "a")) != "A" || readFile(t, filepath.Join(dir, "b")) != "B" {
+		t.Error("committed files have the wrong content")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Errorf("%d files after commit, want 2", len(files))
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	dir := t.TempDir()
+	tx := &Transaction{}
+	writeFile(t, tx, filepath.Join(dir, "a"), "A").Close()
+	writeFile(t, tx, filepath.Join(dir, "b"), "B")
+	tx.Rollback()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("rollback left %d files", len(files))
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("Commit succeeded after Rollback")
+	}
+}
+
+func TestTransactionCreateTwice(t *testing.T) {
+	dir := t.TempDir()
+	tx := &Transaction{Overwrite: true}
+	defer tx.Rollback()
+	writeFile(t, tx, filepath.Join(dir, "a"), "A").Close()
+	if _, err := tx.Create(DirFS{Dir: dir}, "a"); err == nil {
+		t.Error("Create succeeded for a file already created in the transaction")
+	}
+}
+
+func TestTransactionOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a")
+	if err := os.WriteFile(name, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Transaction{}).Create(DirFS{}, name); !errors.Is(err, ErrExist) {
+		t.Fatalf("Create error = %v, want ErrExist", err)
+	}
+
+	tx := &Transaction{Overwrite: true}
+	writeFile(t, tx, name, "new").Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, name); got != "new" {
+		t.Errorf("content %q, want new", got)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files after overwrite, want 1", len(files))
+	}
+}
+
+func TestTransactionCommitRestoresOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transaction{Overwrite: true}
+	writeFile(t, tx, a, "new").Close()
+	writeFile(t, tx, b, "B").Close()
+	// make the second rename fail by removing its temporary file
+	if err := os.Remove(tx.files[1].StagedFile.(*localFile).tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit succeeded")
+	}
+	if got := readFile(t, a); got != "old" {
+		t.Errorf("replaced file not restored: content %q", got)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files after failed commit, want 1", len(files))
+	}
+}
+
+func TestSeparateFileSystems(t *testing.T) {
+	elemDir, mapDir := t.TempDir(), t.TempDir()
+	tx := &Transaction{}
+	elemConfig := &Config{FS: DirFS{Dir: elemDir}, Tx: tx}
+	mapConfig := &Config{FS: DirFS{Dir: mapDir}, Tx: tx}
+	recs := [][]string{{"R1", "x"}, {"R2", "y"}}
+	get := func(rec []string, id string) []string { return []string{id, rec[1]} }
+	ids, err := elemConfig.WriteToFileContext(context.Background(), recs, "x.psv", []string{"x_id", "x"}, get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mapConfig.WriteMapToFileContext(context.Background(), [][]string{{"R1", ids["R1"]}, {"R2", ids["R2"]}}, "map.psv"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join(elemDir, "x.psv"), filepath.Join(mapDir, "map.psv")} {
+		if _, err := os.Stat(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(elemDir, "map.psv")); err == nil {
+		t.Error("map written to the element directory")
+	}
+}
@@ -0,0 +1,314 @@
+package idfactor_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"xor/lib/idfactor"
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+	"xor/lib/synth"
+)
+
+// number of records in each synthetic data set. Large enough that the rank
+// correlation of a random permutation is tightly concentrated around zero.
+const numRecords = 4000
+
+// maximum absolute rank correlation tolerated between two orderings. The
+// standard deviation of Spearman's rho for independent orderings of n items is
+// 1/sqrt(n-1), so this is more than six standard deviations at numRecords.
+const maxCorrelation = 0.1
+
+// elementWriter writes the identity elements of a list of records to an
+// io.Writer and returns a map from record ids to element ids.
+type elementWriter func(recs [][]string, w io.Writer) map[string]string
+
+// elementType names an element file and the function that produces it.
+type elementType struct {
+	name  string
+	write elementWriter
+}
+
+var atRiskElements = []elementType{
+	{"name_dob", atrisk.WriteNameDob},
+	{"ssn", atrisk.WriteSsn},
+	{"address", atrisk.WriteAddress},
+	{"phone", atrisk.WritePhone},
+	{"email", atrisk.WriteEmail},
+	{"name_address", atrisk.WriteNameAddress},
+	{"name_phone", atrisk.WriteNamePhone},
+	{"username", atrisk.WriteUserName},
+}
+
+var compromisedElements = []elementType{
+	{"name_dob", compromised.WriteNameDob},
+	{"ssn", compromised.WriteSsn},
+	{"address", compromised.WriteAddress},
+	{"phone", compromised.WritePhone},
+	{"email", compromised.WriteEmail},
+	{"name_address", compromised.WriteNameAddress},
+	{"name_phone", compromised.WriteNamePhone},
+	{"username", compromised.WriteUserName},
+}
+
+// factoring is the result of factoring a synthetic data set.
+type factoring struct {
+	recs  [][]string
+	ids   [][]string            // identity map returned by IDFactor
+	files map[string][][]string // parsed element files by element type name
+}
+
+//------------------------------------------------------------------------------
+// factoring synthetic data
+//------------------------------------------------------------------------------
+
+// factor runs IDFactor over recs, capturing each element file in memory.
+func factor(t *testing.T, recs [][]string, elements []elementType) *factoring {
+	bufs := make([]bytes.Buffer, len(elements))
+	factorers := make([]idfactor.Factorer, len(elements))
+	for i, e := range elements {
+		i, e := i, e
+		factorers[i] = func(recs [][]string) map[string]string {
+			return e.write(recs, &bufs[i])
+		}
+	}
+	ids, err := idfactor.IDFactor(recs, factorers...)
+	if err != nil {
+		t.Fatalf("IDFactor: %s", err)
+	}
+	f := &factoring{recs: recs, ids: ids, files: make(map[string][][]string)}
+	for i, e := range elements {
+		reader := csv.NewReader(&bufs[i])
+		reader.Comma = '|'
+		rows, err := reader.ReadAll()
+		if err != nil {
+			t.Fatalf("reading %s elements: %s", e.name, err)
+		}
+		if len(rows) == 0 {
+			t.Fatalf("%s elements: missing header", e.name)
+		}
+		f.files[e.name] = rows
+	}
+	return f
+}
+
+// idColumn returns the position of the element id in the given file header.
+func idColumn(t *testing.T, name string, header []string) int {
+	for i, h := range header {
+		if h == name+"_id" {
+			return i
+		}
+	}
+	// name_dob elements use a name_id column
+	for i, h := range header {
+		if strings.HasSuffix(h, "_id") && h != "breach_id" {
+			return i
+		}
+	}
+	t.Fatalf("%s elements: no element id column in header %v", name, header)
+	return -1
+}
+
+// positions returns, for each element type, a map from record index to the
+// row position of that record's element in the element file.
+func (f *factoring) positions(t *testing.T, elements []elementType) map[string]map[int]int {
+	// element id -> record index
+	owner := make(map[string]int)
+	for i, row := range f.ids {
+		for _, id := range row[1:] {
+			if id != "" {
+				owner[id] = i
+			}
+		}
+	}
+	pos := make(map[string]map[int]int)
+	for _, e := range elements {
+		rows := f.files[e.name]
+		col := idColumn(t, e.name, rows[0])
+		pos[e.name] = make(map[int]int)
+		for p, row := range rows[1:] {
+			i, ok := owner[row[col]]
+			if !ok {
+				t.Fatalf("%s elements: element id %s is not in the id map", e.name, row[col])
+			}
+			pos[e.name][i] = p
+		}
+	}
+	return pos
+}
+
+//------------------------------------------------------------------------------
+// statistics
+//------------------------------------------------------------------------------
+
+// ranks replaces each value with its rank in ascending order.
+func ranks(xs []float64) []float64 {
+	idx := make([]int, len(xs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return xs[idx[a]] < xs[idx[b]] })
+	r := make([]float64, len(xs))
+	for rank, i := range idx {
+		r[i] = float64(rank)
+	}
+	return r
+}
+
+// spearman returns the rank correlation coefficient of two paired samples.
+func spearman(xs, ys []float64) float64 {
+	rx, ry := ranks(xs), ranks(ys)
+	n := float64(len(rx))
+	mean := (n - 1) / 2
+	var cov, vx, vy float64
+	for i := range rx {
+		dx, dy := rx[i]-mean, ry[i]-mean
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+//------------------------------------------------------------------------------
+// tests
+//------------------------------------------------------------------------------
+
+var dataSets = []struct {
+	name     string
+	cfg      synth.Config
+	elements []elementType
+}{
+	{"atrisk/full", synth.Config{}, atRiskElements},
+	{"atrisk/sparse", synth.Config{EmptyRate: 0.3, DuplicateRate: 0.2}, atRiskElements},
+	{"compromised/full", synth.Config{Compromised: true, Breaches: 5}, compromisedElements},
+	{"compromised/sparse", synth.Config{Compromised: true, Breaches: 5, EmptyRate: 0.3, DuplicateRate: 0.2}, compromisedElements},
+}
+
+func forEachDataSet(t *testing.T, test func(t *testing.T, f *factoring, elements []elementType)) {
+	for k, ds := range dataSets {
+		ds := ds
+		ds.cfg.Seed = int64(k + 1)
+		t.Run(ds.name, func(t *testing.T) {
+			recs := synth.New(ds.cfg).Records(numRecords)
+			test(t, factor(t, recs, ds.elements), ds.elements)
+		})
+	}
+}
+
+// TestOrderUncorrelatedWithInput checks that the order of each element file
+// carries no information about the order of the input records.
+func TestOrderUncorrelatedWithInput(t *testing.T) {
+	forEachDataSet(t, func(t *testing.T, f *factoring, elements []elementType) {
+		pos := f.positions(t, elements)
+		for _, e := range elements {
+			var xs, ys []float64
+			for i, p := range pos[e.name] {
+				xs = append(xs, float64(i))
+				ys = append(ys, float64(p))
+			}
+			if rho := spearman(xs, ys); math.Abs(rho) > maxCorrelation {
+				t.Errorf("%s elements: order correlates with input order (rho = %.3f)", e.name, rho)
+			}
+		}
+	})
+}
+
+// TestOrderUncorrelatedAcrossFiles checks that the order of one element file
+// carries no information about the order of any other element file.
+func TestOrderUncorrelatedAcrossFiles(t *testing.T) {
+	forEachDataSet(t, func(t *testing.T, f *factoring, elements []elementType) {
+		pos := f.positions(t, elements)
+		for a := range elements {
+			for b := a + 1; b < len(elements); b++ {
+				pa, pb := pos[elements[a].name], pos[elements[b].name]
+				var xs, ys []float64
+				for i, p := range pa {
+					if q, ok := pb[i]; ok {
+						xs = append(xs, float64(p))
+						ys = append(ys, float64(q))
+					}
+				}
+				if rho := spearman(xs, ys); math.Abs(rho) > maxCorrelation {
+					t.Errorf("%s and %s elements: orders correlate (rho = %.3f)", elements[a].name, elements[b].name, rho)
+				}
+			}
+		}
+	})
+}
+
+// TestElementIDsUnique checks that no element id is repeated, either within
+// an element file or across element files of different types.
+func TestElementIDsUnique(t *testing.T) {
+	forEachDataSet(t, func(t *testing.T, f *factoring, elements []elementType) {
+		seen := make(map[string]string)
+		for _, e := range elements {
+			rows := f.files[e.name]
+			col := idColumn(t, e.name, rows[0])
+			for _, row := range rows[1:] {
+				id := row[col]
+				if id == "" {
+					t.Errorf("%s elements: empty element id", e.name)
+					continue
+				}
+				if prev, ok := seen[id]; ok {
+					if prev == e.name {
+						t.Errorf("%s elements: element id %s repeated", e.name, id)
+					} else {
+						t.Errorf("element id %s reused in %s and %s elements", id, prev, e.name)
+					}
+				}
+				seen[id] = e.name
+			}
+		}
+	})
+}
+
+// TestRecordIDsNotInElements checks that no record id leaks into any field of
+// any element file.
+func TestRecordIDsNotInElements(t *testing.T) {
+	forEachDataSet(t, func(t *testing.T, f *factoring, elements []elementType) {
+		recordIDs := make(map[string]bool, len(f.recs))
+		for _, rec := range f.recs {
+			recordIDs[rec[0]] = true
+		}
+		for _, e := range elements {
+			for _, row := range f.files[e.name][1:] {
+				for _, field := range row {
+					if recordIDs[field] {
+						t.Fatalf("%s elements: record id leaked in field %q", e.name, field)
+					}
+				}
+			}
+		}
+	})
+}
+
+// TestElementCounts checks that each element file has exactly one row for
+// every record with a nonempty element and that the identity map agrees.
+func TestElementCounts(t *testing.T) {
+	forEachDataSet(t, func(t *testing.T, f *factoring, elements []elementType) {
+		if len(f.ids) != len(f.recs) {
+			t.Fatalf("identity map has %d rows, want %d", len(f.ids), len(f.recs))
+		}
+		for j, e := range elements {
+			n := 0
+			for _, row := range f.ids {
+				if row[1+j] != "" {
+					n++
+				}
+			}
+			if got := len(f.files[e.name]) - 1; got != n {
+				t.Errorf("%s elements: %d rows, identity map references %d", e.name, got, n)
+			}
+		}
+	})
+}
@@ -0,0 +1,175 @@
+// Package ids provides generators for the element ids written to element files
+// and identity maps.
+package ids
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+	"time"
+
+	"xor/lib/csprng"
+	"xor/lib/uuid"
+)
+
+// Generator generates element ids using randomness drawn from a Source.
+type Generator interface {
+	New(src csprng.Source) string
+}
+
+// Schemes lists the names of the id schemes accepted by Parse.
+var Schemes = []string{"uuid4", "uuid7", "ulid", "base32", "base58"}
+
+// Parse returns the Generator for the named id scheme. If randomTime is true
+// then time based schemes hide the generation time by drawing the timestamp
+// at random from the current day. If t is not zero it is used in place of the
+// current time.
+func Parse(scheme string, randomTime bool, t time.Time) (Generator, error) {
+	switch scheme {
+	case "uuid4":
+		return UUIDv4{}, nil
+	case "uuid7":
+		return UUIDv7{RandomTime: randomTime, Time: t}, nil
+	case "ulid":
+		return ULID{RandomTime: randomTime, Time: t}, nil
+	case "base32":
+		return Base32{}, nil
+	case "base58":
+		return Base58{}, nil
+	}
+	return nil, fmt.Errorf("ids: unknown id scheme %q (expected one of %s)", scheme, strings.Join(Schemes, ", "))
+}
+
+// TimeOrdered reports whether ids of the named scheme carry the date on which
+// they were generated.
+func TimeOrdered(scheme string) bool {
+	return scheme == "uuid7" || scheme == "ulid"
+}
+
+//------------------------------------------------------------------------------
+// UUIDs
+//------------------------------------------------------------------------------
+
+// UUIDv4 generates random version 4 UUIDs in canonical string form.
+type UUIDv4 struct{}
+
+// New returns a new id.
+func (UUIDv4) New(src csprng.Source) string {
+	return uuid.NewFrom(src)
+}
+
+// UUIDv7 generates time ordered version 7 UUIDs in canonical string form.
+type UUIDv7 struct {
+	// RandomTime hides the generation time by drawing the timestamp at
+	// random from the day containing it.
+	RandomTime bool
+	// Time is used in place of the current time if it is not zero.
+	Time time.Time
+}
+
+// New returns a new id.
+func (g UUIDv7) New(src csprng.Source) string {
+	return uuid.NewV7From(src, timestamp(src, g.Time, g.RandomTime))
+}
+
+//------------------------------------------------------------------------------
+// ULIDs and base encoded ids
+//------------------------------------------------------------------------------
+
+// crockford is Crockford's base32 alphabet, which omits I, L, O and U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates 26 character ULIDs: a 48-bit millisecond timestamp followed
+// by 80 random bits in Crockford base32.
+type ULID struct {
+	// RandomTime hides the generation time by drawing the timestamp at
+	// random from the day containing it.
+	RandomTime bool
+	// Time is used in place of the current time if it is not zero.
+	Time time.Time
+}
+
+// New returns a new id.
+func (g ULID) New(src csprng.Source) string {
+	var u [16]byte
+	src.Read(u[6:])
+	ms := timestamp(src, g.Time, g.RandomTime)
+	for i := 5; i >= 0; i-- {
+		u[i] = byte(ms)
+		ms >>= 8
+	}
+	return base32(&u)
+}
+
+// Base32 generates 128-bit random ids as 26 characters of Crockford base32.
+type Base32 struct{}
+
+// New returns a new id.
+func (Base32) New(src csprng.Source) string {
+	var u [16]byte
+	src.Read(u[:])
+	return base32(&u)
+}
+
+// base32 encodes 128 bits as 26 Crockford base32 characters, most
+// significant first, with the first character holding the top 3 bits
+func base32(u *[16]byte) string {
+	var buf [26]byte
+	hi, lo := halves(u)
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// bitcoin is the base58 alphabet used by Bitcoin, which omits 0, O, I and l
+const bitcoin = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Base58 generates 128-bit random ids as 22 characters of base58, padded on
+// the left so that all ids have the same length.
+type Base58 struct{}
+
+// New returns a new id.
+func (Base58) New(src csprng.Source) string {
+	var u [16]byte
+	src.Read(u[:])
+	var buf [22]byte
+	hi, lo := halves(&u)
+	for i := len(buf) - 1; i >= 0; i-- {
+		// long division of the 128-bit value by 58
+		var r uint64
+		hi, r = bits.Div64(0, hi, 58)
+		lo, r = bits.Div64(r, lo, 58)
+		buf[i] = bitcoin[r]
+	}
+	return string(buf[:])
+}
+
+//------------------------------------------------------------------------------
+// utility functions
+//------------------------------------------------------------------------------
+
+// halves returns the high and low 64 bits of a big endian 128-bit value
+func halves(u *[16]byte) (hi, lo uint64) {
+	return binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+}
+
+// milliseconds in a day
+const dayMillis = 24 * 60 * 60 * 1000
+
+// timestamp returns the Unix time in milliseconds of t, or of the current time
+// if t is zero. If random is true then a time is drawn at random from the UTC
+// day containing it instead.
+func timestamp(src csprng.Source, t time.Time, random bool) uint64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	ms := uint64(t.UnixMilli())
+	if random {
+		ms = ms - ms%dayMillis + csprng.Bounded(src, dayMillis)
+	}
+	return ms
+}
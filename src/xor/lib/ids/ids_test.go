@@ -0,0 +1,112 @@
+package ids
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"xor/lib/csprng"
+)
+
+func TestFormats(t *testing.T) {
+	for _, tc := range []struct {
+		scheme string
+		format string
+	}{
+		{"uuid4", `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{"uuid7", `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{"ulid", `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		{"base32", `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		{"base58", `^[1-9A-HJ-NP-Za-km-z]{22}$`},
+	} {
+		g, err := Parse(tc.scheme, false, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		re := regexp.MustCompile(tc.format)
+		src := csprng.New()
+		seen := make(map[string]bool)
+		for i := 0; i < 5000; i++ {
+			id := g.New(src)
+			if !re.MatchString(id) {
+				t.Fatalf("%s: %q has the wrong format", tc.scheme, id)
+			}
+			if seen[id] {
+				t.Fatalf("%s: %q repeated", tc.scheme, id)
+			}
+			seen[id] = true
+		}
+	}
+	if _, err := Parse("uuid1", false, time.Time{}); err == nil {
+		t.Error("Parse accepted an unknown scheme")
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	var max [16]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	if got := base32(&max); got != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("base32(max) = %s", got)
+	}
+	one := [16]byte{15: 1}
+	if got := base32(&one); got != "00000000000000000000000001" {
+		t.Errorf("base32(1) = %s", got)
+	}
+	src := zeroSource{}
+	if got := (Base58{}).New(src); got != "1111111111111111111111" {
+		t.Errorf("base58(0) = %s", got)
+	}
+}
+
+func TestTimeOrdered(t *testing.T) {
+	src := csprng.New()
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, scheme := range Schemes {
+		if !TimeOrdered(scheme) {
+			continue
+		}
+		var got []string
+		for i := 0; i < 100; i++ {
+			g, _ := Parse(scheme, false, start.Add(time.Duration(i)*time.Millisecond))
+			got = append(got, g.New(src))
+		}
+		if !sort.StringsAreSorted(got) {
+			t.Errorf("%s ids are not time ordered", scheme)
+		}
+	}
+	if TimeOrdered("uuid4") {
+		t.Error("uuid4 ids are taken for time ordered")
+	}
+}
+
+func TestRandomTime(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	day := uint64(now.Truncate(24 * time.Hour).UnixMilli())
+	src := csprng.New()
+	distinct := make(map[uint64]bool)
+	for i := 0; i < 100; i++ {
+		ms := timestamp(src, now, true)
+		if ms < day || ms >= day+dayMillis {
+			t.Fatalf("random timestamp %d outside the day starting %d", ms, day)
+		}
+		distinct[ms] = true
+	}
+	if len(distinct) < 90 {
+		t.Errorf("only %d distinct random timestamps in 100", len(distinct))
+	}
+}
+
+// zeroSource returns only zero bits
+type zeroSource struct{}
+
+func (zeroSource) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (zeroSource) Uint64() uint64 { return 0 }
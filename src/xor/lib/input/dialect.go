@@ -0,0 +1,139 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+// Delimited text dialects that encoding/csv cannot read: multi-character
+// delimiters, other quote characters and escape characters.
+//------------------------------------------------------------------------------
+
+// parse errors
+var (
+	errBareQuote = errors.New("bare quote in non-quoted field")
+	errQuote     = errors.New("extraneous or missing quote in quoted field")
+	errUTF8      = errors.New("invalid UTF-8")
+)
+
+// parser parses records in a dialect
+type parser struct {
+	delim  string
+	quote  rune
+	escape rune
+}
+
+// parse parses text as a single record. If text ends inside a quoted field or
+// after an escape character then more is true and text, followed by a line
+// break and the next line, should be parsed again.
+func (p *parser) parse(text string) (rec []string, more bool, err error) {
+	var field strings.Builder
+	i := 0
+	for {
+		field.Reset()
+		r, size := utf8.DecodeRuneInString(text[i:])
+
+		// quoted field
+		if p.quote != 0 && i < len(text) && r == p.quote {
+			i += size
+			for closed := false; !closed; {
+				if i >= len(text) {
+					return nil, true, nil
+				}
+				r, size := utf8.DecodeRuneInString(text[i:])
+				switch {
+				case p.escape != 0 && r == p.escape:
+					if i+size >= len(text) {
+						return nil, true, nil
+					}
+					next, n := utf8.DecodeRuneInString(text[i+size:])
+					field.WriteRune(unescape(next))
+					i += size + n
+				case r == p.quote:
+					i += size
+					if next, n := utf8.DecodeRuneInString(text[i:]); i < len(text) && next == p.quote {
+						// doubled quote
+						field.WriteRune(p.quote)
+						i += n
+					} else {
+						closed = true
+					}
+				default:
+					field.WriteString(text[i : i+size])
+					i += size
+				}
+			}
+			rec = append(rec, field.String())
+			if i == len(text) {
+				return rec, false, nil
+			}
+			if !strings.HasPrefix(text[i:], p.delim) {
+				return nil, false, errQuote
+			}
+			i += len(p.delim)
+			continue
+		}
+
+		// unquoted field
+		for {
+			if i >= len(text) {
+				return append(rec, field.String()), false, nil
+			}
+			if strings.HasPrefix(text[i:], p.delim) {
+				rec = append(rec, field.String())
+				i += len(p.delim)
+				break
+			}
+			r, size := utf8.DecodeRuneInString(text[i:])
+			switch {
+			case p.escape != 0 && r == p.escape:
+				if i+size >= len(text) {
+					// escaped line break
+					return nil, true, nil
+				}
+				next, n := utf8.DecodeRuneInString(text[i+size:])
+				field.WriteRune(unescape(next))
+				i += size + n
+			case p.quote != 0 && r == p.quote:
+				return nil, false, errBareQuote
+			default:
+				field.WriteString(text[i : i+size])
+				i += size
+			}
+		}
+	}
+}
+
+// unescape returns the character represented by an escaped character. The
+// escape sequences match those written by idfactor with -quote never.
+func unescape(r rune) rune {
+	switch r {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	}
+	return r
+}
+
+// validate reports whether records can be parsed unambiguously
+func (p *parser) validate() error {
+	switch {
+	case p.delim == "":
+		return fmt.Errorf("input: empty delimiter")
+	case strings.ContainsAny(p.delim, "\r\n"):
+		return fmt.Errorf("input: delimiter contains a line break")
+	case p.quote != 0 && strings.ContainsRune(p.delim, p.quote):
+		return fmt.Errorf("input: delimiter contains the quote character")
+	case p.escape != 0 && strings.ContainsRune(p.delim, p.escape):
+		return fmt.Errorf("input: delimiter contains the escape character")
+	case p.quote != 0 && p.quote == p.escape:
+		return fmt.Errorf("input: quote and escape characters are the same")
+	case p.quote == '\r' || p.quote == '\n' || p.escape == '\r' || p.escape == '\n':
+		return fmt.Errorf("input: quote or escape character is a line break")
+	}
+	return nil
+}
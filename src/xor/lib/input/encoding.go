@@ -0,0 +1,295 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+// Character encodings. Input is transcoded to UTF-8 before it is parsed so
+// that the element getters only ever see UTF-8.
+//------------------------------------------------------------------------------
+
+// Encoding names
+const (
+	Auto        = "auto"
+	UTF8        = "utf-8"
+	Windows1252 = "windows-1252"
+	Latin1      = "latin-1"
+	UTF16LE     = "utf-16le"
+	UTF16BE     = "utf-16be"
+)
+
+// sniffLen is the length of the prefix of the input from which the encoding is
+// detected
+const sniffLen = 64 << 10
+
+// ErrDetect reports invalid UTF-8 after the sniffed prefix of input that was
+// detected as UTF-8.
+var ErrDetect = errors.New("input: invalid UTF-8 in input detected as UTF-8")
+
+// Encodings lists the names accepted by NewDecoder.
+var Encodings = []string{Auto, UTF8, Windows1252, Latin1, UTF16LE, UTF16BE}
+
+// aliases maps alternative encoding names to their canonical names
+var aliases = map[string]string{
+	"utf8":       UTF8,
+	"cp1252":     Windows1252,
+	"latin1":     Latin1,
+	"iso-8859-1": Latin1,
+}
+
+// byte order marks
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// windows1252 maps the bytes 0x80 to 0x9F to runes in Windows-1252. The other
+// bytes map to the rune of the same value, as in Latin-1. Zero marks a byte
+// that is not defined.
+var windows1252 = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+// Decoder is an io.Reader that transcodes its input to UTF-8. A byte order
+// mark at the start of the input is removed. Invalid sequences are counted;
+// in UTF-8 input they are passed through unchanged so that they can be
+// rejected with their row, while in other encodings they are replaced by
+// U+FFFD.
+type Decoder struct {
+	// Encoding is the name of the declared or detected input encoding
+	Encoding string
+	// Detected is true if the encoding was detected rather than declared
+	Detected bool
+	// Invalid is the number of invalid sequences decoded so far
+	Invalid int
+	// FirstInvalidLine is the line of the first invalid sequence
+	FirstInvalidLine int
+
+	r       *bufio.Reader
+	buf     []byte
+	pending []byte
+	out     []byte
+	line    int
+	err     error
+	// guessed is true if the input was guessed to be UTF-8 from its prefix
+	guessed bool
+	// fail is returned once the output decoded before it has been read
+	fail error
+}
+
+// NewDecoder returns a Decoder that reads input in the named encoding from r.
+// With Auto the encoding is taken from a byte order mark or else guessed from
+// the first 64 KiB of input: UTF-8 if it is valid UTF-8, otherwise
+// Windows-1252, which decodes every printable Latin-1 character the same way.
+// Input guessed to be UTF-8 that turns out not to be fails with ErrDetect once
+// the output before the invalid sequence has been read.
+func NewDecoder(r io.Reader, encoding string) (*Decoder, error) {
+	encoding = strings.ToLower(encoding)
+	if alias, ok := aliases[encoding]; ok {
+		encoding = alias
+	}
+	d := &Decoder{
+		Encoding: encoding,
+		buf:      make([]byte, 32<<10),
+		line:     1,
+	}
+	switch encoding {
+	case Auto:
+		d.r = bufio.NewReaderSize(r, sniffLen)
+		prefix, err := d.r.Peek(sniffLen)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(prefix) == sniffLen {
+			prefix = trimRune(prefix)
+		}
+		d.Detected = true
+		d.Encoding = detect(prefix)
+		d.guessed = d.Encoding == UTF8 && !bytes.HasPrefix(prefix, bomUTF8)
+	case UTF8, Windows1252, Latin1, UTF16LE, UTF16BE:
+		d.r = bufio.NewReader(r)
+	default:
+		return nil, fmt.Errorf(`input: unknown encoding "%s"`, encoding)
+	}
+	start, _ := d.r.Peek(len(bomUTF8))
+	// remove a byte order mark
+	for enc, bom := range map[string][]byte{UTF8: bomUTF8, UTF16LE: bomUTF16LE, UTF16BE: bomUTF16BE} {
+		if d.Encoding == enc && bytes.HasPrefix(start, bom) {
+			d.r.Discard(len(bom))
+		}
+	}
+	return d, nil
+}
+
+// detect guesses the encoding of the given input
+func detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return UTF8
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return UTF16LE
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return UTF16BE
+	}
+	// UTF-16 text in the Latin alphabet has a zero in every other byte
+	var zeros [2]int
+	for i, b := range data {
+		if b == 0 {
+			zeros[i%2]++
+		}
+	}
+	switch {
+	case zeros[1] > len(data)/4:
+		return UTF16LE
+	case zeros[0] > len(data)/4:
+		return UTF16BE
+	}
+	if utf8.Valid(data) {
+		return UTF8
+	}
+	return Windows1252
+}
+
+// trimRune removes an incomplete UTF-8 sequence from the end of data
+func trimRune(data []byte) []byte {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// Read reads transcoded input.
+func (d *Decoder) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.r.Read(d.buf)
+		d.decode(d.buf[:n], err == io.EOF)
+		d.err = err
+		if d.fail != nil {
+			d.err = d.fail
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// decode transcodes in, together with any bytes left over from the last call,
+// to d.out. Bytes of an incomplete sequence are kept for the next call unless
+// eof is true.
+func (d *Decoder) decode(in []byte, eof bool) {
+	data := append(d.pending, in...)
+	d.out = d.out[:0]
+	i := 0
+	switch d.Encoding {
+	case UTF8:
+		// valid and invalid sequences alike are passed through unchanged
+		for i < len(data) {
+			if data[i] < utf8.RuneSelf {
+				if data[i] == '\n' {
+					d.line++
+				}
+				i++
+				continue
+			}
+			if !utf8.FullRune(data[i:]) && !eof {
+				break
+			}
+			r, size := utf8.DecodeRune(data[i:])
+			if r == utf8.RuneError && size <= 1 {
+				if d.guessed {
+					// the sniffed prefix was misleading
+					d.fail = fmt.Errorf("%w on line %d", ErrDetect, d.line)
+					break
+				}
+				d.invalid()
+			}
+			i += size
+		}
+		d.out = append(d.out, data[:i]...)
+	case Windows1252, Latin1:
+		for ; i < len(data); i++ {
+			r := rune(data[i])
+			if d.Encoding == Windows1252 && r >= 0x80 && r < 0xA0 {
+				if r = windows1252[r-0x80]; r == 0 {
+					d.invalid()
+					r = utf8.RuneError
+				}
+			}
+			d.emit(r)
+		}
+	case UTF16LE, UTF16BE:
+		unit := func(j int) rune {
+			if d.Encoding == UTF16LE {
+				return rune(data[j]) | rune(data[j+1])<<8
+			}
+			return rune(data[j])<<8 | rune(data[j+1])
+		}
+	units:
+		for len(data)-i >= 2 {
+			r := unit(i)
+			switch {
+			case utf16.IsSurrogate(r) && r < 0xDC00:
+				if len(data)-i < 4 && !eof {
+					// wait for the low surrogate
+					break units
+				}
+				if len(data)-i >= 4 {
+					if r2 := utf16.DecodeRune(r, unit(i+2)); r2 != utf8.RuneError {
+						d.emit(r2)
+						i += 4
+						continue
+					}
+				}
+				d.invalid()
+				r = utf8.RuneError
+			case utf16.IsSurrogate(r):
+				d.invalid()
+				r = utf8.RuneError
+			}
+			d.emit(r)
+			i += 2
+		}
+		if eof && i < len(data) {
+			d.invalid()
+			d.emit(utf8.RuneError)
+			i = len(data)
+		}
+	}
+	d.pending = append(d.pending[:0], data[i:]...)
+}
+
+// emit appends a rune to the output
+func (d *Decoder) emit(r rune) {
+	if r == '\n' {
+		d.line++
+	}
+	d.out = utf8.AppendRune(d.out, r)
+}
+
+// invalid counts an invalid sequence on the current line
+func (d *Decoder) invalid() {
+	if d.Invalid == 0 {
+		d.FirstInvalidLine = d.line
+	}
+	d.Invalid++
+}
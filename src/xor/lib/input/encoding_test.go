@@ -0,0 +1,85 @@
+package input
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+// utf16Bytes encodes s in UTF-16 with the given byte order
+func utf16Bytes(s string, littleEndian bool) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		if littleEndian {
+			b = append(b, byte(u), byte(u>>8))
+		} else {
+			b = append(b, byte(u>>8), byte(u))
+		}
+	}
+	return b
+}
+
+func TestDecoder(t *testing.T) {
+	const text = "id|name\n1|José Müller €\n2|Zoë 😀\n"
+	for _, tc := range []struct {
+		name     string
+		encoding string
+		in       []byte
+		want     string
+		detected string
+		invalid  int
+		line     int
+	}{
+		{"utf-8", UTF8, []byte(text), text, UTF8, 0, 0},
+		{"utf-8 bom", Auto, append([]byte{0xEF, 0xBB, 0xBF}, text...), text, UTF8, 0, 0},
+		{"invalid utf-8 detected as windows-1252", Auto, []byte("id\n1\n\xff\n"), "id\n1\nÿ\n", Windows1252, 0, 0},
+		{"utf-8 declared invalid", UTF8, []byte("id\n1\n\xff\n"), "id\n1\n\xff\n", UTF8, 1, 3},
+		{"windows-1252", Auto, []byte("id|name\n1|Jos\xe9 M\xfcller \x80\n"), "id|name\n1|José Müller €\n", Windows1252, 0, 0},
+		{"windows-1252 within the sniffed prefix", Auto, []byte(strings.Repeat("a\n", 20000) + "\xe9\n"), strings.Repeat("a\n", 20000) + "é\n", Windows1252, 0, 0},
+		{"windows-1252 undefined", Windows1252, []byte("a\n\x81\n"), "a\n�\n", Windows1252, 1, 2},
+		{"latin-1", "ISO-8859-1", []byte("Jos\xe9 \x80"), "José \u0080", Latin1, 0, 0},
+		{"utf-16le bom", Auto, append([]byte{0xFF, 0xFE}, utf16Bytes(text, true)...), text, UTF16LE, 0, 0},
+		{"utf-16be bom", Auto, append([]byte{0xFE, 0xFF}, utf16Bytes(text, false)...), text, UTF16BE, 0, 0},
+		{"utf-16le", Auto, utf16Bytes(text, true), text, UTF16LE, 0, 0},
+		{"utf-16le unpaired", UTF16LE, []byte{'a', 0, '\n', 0, 0x00, 0xD8, 'b', 0}, "a\n�b", UTF16LE, 1, 2},
+	} {
+		// read a byte at a time to split sequences across reads
+		d, err := NewDecoder(iotest.OneByteReader(bytes.NewReader(tc.in)), tc.encoding)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		got, err := io.ReadAll(d)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if d.Encoding != tc.detected {
+			t.Errorf("%s: encoding %s, want %s", tc.name, d.Encoding, tc.detected)
+		}
+		if d.Invalid != tc.invalid || d.FirstInvalidLine != tc.line {
+			t.Errorf("%s: %d invalid sequences first on line %d, want %d on line %d", tc.name, d.Invalid, d.FirstInvalidLine, tc.invalid, tc.line)
+		}
+	}
+	// invalid UTF-8 after the sniffed prefix fails after the output before it
+	late := strings.Repeat("a\n", 50000)
+	d, err := NewDecoder(bytes.NewReader([]byte(late+"\xe9\n")), Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(d)
+	if !errors.Is(err, ErrDetect) || !strings.Contains(err.Error(), "line 50001") {
+		t.Errorf("late windows-1252: got error %v, want %v on line 50001", err, ErrDetect)
+	}
+	if string(got) != late {
+		t.Errorf("late windows-1252: got %d bytes before the error, want %d", len(got), len(late))
+	}
+	if _, err := NewDecoder(bytes.NewReader(nil), "ebcdic"); err == nil {
+		t.Error("unknown encoding was accepted")
+	}
+}
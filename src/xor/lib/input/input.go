@@ -0,0 +1,326 @@
+// Package input reads identity records from delimited text files.
+package input
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reject describes an input row that could not be read.
+type Reject struct {
+	// File is the name of the input file, if known
+	File string
+	// Line is the line number of the row, counting from 1
+	Line int
+	// Reason says why the row was rejected
+	Reason string
+	// Text is the raw text of the row without its line terminator
+	Text string
+}
+
+// RejectHeader is the column header of a rejects file.
+var RejectHeader = []string{"file", "line", "reason", "text"}
+
+// Dialect describes how fields are delimited and quoted.
+type Dialect struct {
+	// Delimiter is the field delimiter. It may be more than one character.
+	Delimiter string
+	// Quote is the quote character, or 0 if fields are never quoted. A
+	// quote character inside a quoted field is doubled.
+	Quote rune
+	// Escape, if not 0, is the escape character. It makes the character after
+	// it literal, reading n as a line feed and r as a carriage return, as
+	// written by idfactor -quote never.
+	Escape rune
+}
+
+// Validate reports whether the dialect's delimiter, quote and escape
+// characters can be read unambiguously.
+func (d Dialect) Validate() error {
+	_, err := d.parser()
+	return err
+}
+
+// parser returns the parser of the dialect
+func (d Dialect) parser() (*parser, error) {
+	p := &parser{delim: d.Delimiter, quote: d.Quote, escape: d.Escape}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reader reads a header and identity records from delimited text.
+type Reader struct {
+	Dialect
+	// FieldsPerRecord is the number of fields in the header and every record
+	FieldsPerRecord int
+	// Tolerant selects per row error recovery. Each line is then read as a
+	// single record, and malformed rows are passed to Reject and skipped
+	// rather than ending the read. Quoted fields may not span lines.
+	Tolerant bool
+	// Reject, if not nil, is called with each malformed row in tolerant mode.
+	Reject func(r Reject) error
+	// Name is the name of the input, given to Reject
+	Name string
+
+	r     io.Reader
+	lines []int
+}
+
+// NewReader returns a new Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{Dialect: Dialect{Delimiter: "|", Quote: '"'}, r: r}
+}
+
+// comma returns the delimiter as a rune and true if the dialect can be read
+// by encoding/csv
+func (r *Reader) comma() (rune, bool) {
+	c, size := utf8.DecodeRuneInString(r.Delimiter)
+	return c, size == len(r.Delimiter) && r.Quote == '"' && r.Escape == 0
+}
+
+// ReadAll reads the header and all records. Input with no header at all has
+// no records and a nil header.
+func (r *Reader) ReadAll() (header []string, recs [][]string, err error) {
+	p, err := r.parser()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.lines = nil
+	if r.Tolerant {
+		return r.readTolerant(p)
+	}
+	comma, ok := r.comma()
+	if !ok {
+		return r.readDialect(p)
+	}
+	reader := csv.NewReader(r.r)
+	reader.Comma = comma
+	reader.FieldsPerRecord = r.FieldsPerRecord
+	header, err = reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !validUTF8(header) {
+		line, _ := reader.FieldPos(0)
+		return nil, nil, fmt.Errorf("record on line %d: %w", line, errUTF8)
+	}
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			return header, recs, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if !validUTF8(rec) {
+			return nil, nil, fmt.Errorf("record on line %d: %w", line, errUTF8)
+		}
+		recs = append(recs, rec)
+		r.lines = append(r.lines, line)
+	}
+}
+
+// validUTF8 reports whether every field of rec is valid UTF-8
+func validUTF8(rec []string) bool {
+	for _, field := range rec {
+		if !utf8.ValidString(field) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lines returns the line number, counting from 1, on which each record read
+// by ReadAll starts.
+func (r *Reader) Lines() []int {
+	return r.lines
+}
+
+// readDialect reads input that encoding/csv cannot. Like encoding/csv it skips
+// empty lines, and a record continues onto the next line if a line ends inside
+// a quoted field or with an escape character.
+func (r *Reader) readDialect(p *parser) (header []string, recs [][]string, err error) {
+	br := bufio.NewReader(r.r)
+	fields := r.FieldsPerRecord
+	var pending string
+	start := 0
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		eof := err == io.EOF
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		if start > 0 {
+			text = pending + "\n" + text
+		} else if text == "" {
+			if eof {
+				return header, recs, nil
+			}
+			continue
+		} else {
+			start = line
+		}
+		rec, more, err := p.parse(text)
+		switch {
+		case !utf8.ValidString(text):
+			return nil, nil, fmt.Errorf("record on line %d: %w", start, errUTF8)
+		case err != nil:
+			return nil, nil, fmt.Errorf("record on line %d: %w", start, err)
+		case more && eof:
+			return nil, nil, fmt.Errorf("record on line %d: unterminated quoted field", start)
+		case more:
+			pending = text
+			continue
+		case fields == 0:
+			fields = len(rec)
+		case fields > 0 && len(rec) != fields:
+			return nil, nil, fmt.Errorf("record on line %d: wrong number of fields", start)
+		}
+		if header == nil {
+			header = rec
+		} else {
+			recs = append(recs, rec)
+			r.lines = append(r.lines, start)
+		}
+		if eof {
+			return header, recs, nil
+		}
+		pending, start = "", 0
+	}
+}
+
+// readTolerant reads the input line by line, rejecting malformed rows
+func (r *Reader) readTolerant(p *parser) (header []string, recs [][]string, err error) {
+	br := bufio.NewReader(r.r)
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if text == "" && err == io.EOF {
+			return header, recs, nil
+		}
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		if text != "" {
+			rec, reason := r.parseLine(p, text)
+			switch {
+			case header == nil && reason != "":
+				// nothing can be read without a header
+				return nil, nil, fmt.Errorf("line %d: bad header: %s", line, reason)
+			case header == nil:
+				header = rec
+			case reason != "":
+				if r.Reject != nil {
+					if err := r.Reject(Reject{r.Name, line, reason, text}); err != nil {
+						return nil, nil, err
+					}
+				}
+			default:
+				recs = append(recs, rec)
+				r.lines = append(r.lines, line)
+			}
+		}
+		if err == io.EOF {
+			return header, recs, nil
+		}
+	}
+}
+
+// parseLine parses a single line as a record. It returns the reason the line
+// is malformed, if it is.
+func (r *Reader) parseLine(p *parser, text string) (rec []string, reason string) {
+	if !utf8.ValidString(text) {
+		return nil, errUTF8.Error()
+	}
+	comma, ok := r.comma()
+	if !ok {
+		rec, more, err := p.parse(text)
+		switch {
+		case err != nil:
+			return nil, err.Error()
+		case more:
+			return nil, "unterminated quoted field"
+		case r.FieldsPerRecord > 0 && len(rec) != r.FieldsPerRecord:
+			return nil, fmt.Sprintf("wrong number of fields (expected %d, got %d)", r.FieldsPerRecord, len(rec))
+		}
+		return rec, ""
+	}
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	rec, err := reader.Read()
+	var perr *csv.ParseError
+	switch {
+	case errors.As(err, &perr):
+		if perr.Err == io.ErrUnexpectedEOF || perr.Err == csv.ErrQuote {
+			return nil, "unterminated quoted field"
+		}
+		return nil, perr.Err.Error()
+	case err != nil:
+		return nil, err.Error()
+	case r.FieldsPerRecord > 0 && len(rec) != r.FieldsPerRecord:
+		return nil, fmt.Sprintf("wrong number of fields (expected %d, got %d)", r.FieldsPerRecord, len(rec))
+	}
+	return rec, ""
+}
+
+// RowWriter writes rows of fields. A *csv.Writer is a RowWriter.
+type RowWriter interface {
+	Write(row []string) error
+	Flush()
+	Error() error
+}
+
+// RejectWriter writes rejected rows to a rejects file and counts them by
+// reason.
+type RejectWriter struct {
+	// Counts is the number of rejected rows by reason
+	Counts map[string]int
+	// Total is the number of rejected rows
+	Total int
+
+	writer RowWriter
+}
+
+// NewRejectWriter returns a RejectWriter that writes rows with w, or only
+// counts rejected rows if w is nil.
+func NewRejectWriter(w RowWriter) (*RejectWriter, error) {
+	rw := &RejectWriter{Counts: make(map[string]int), writer: w}
+	if w != nil {
+		if err := rw.writer.Write(RejectHeader); err != nil {
+			return nil, err
+		}
+	}
+	return rw, nil
+}
+
+// Reject records a rejected row.
+func (rw *RejectWriter) Reject(r Reject) error {
+	rw.Counts[r.Reason]++
+	rw.Total++
+	if rw.writer == nil {
+		return nil
+	}
+	return rw.writer.Write([]string{r.File, fmt.Sprint(r.Line), r.Reason, r.Text})
+}
+
+// Flush writes any buffered rows to the rejects file.
+func (rw *RejectWriter) Flush() error {
+	if rw.writer == nil {
+		return nil
+	}
+	rw.writer.Flush()
+	return rw.writer.Error()
+}
@@ -0,0 +1,186 @@
+package input
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = "id|a|b\r\n" +
+	"1|x|y\r\n" +
+	"2|x\n" +
+	"3|\"x|y\n" +
+	"\n" +
+	"4|x\"y|z\n" +
+	"5|\xff|z\n" +
+	"6|\"x|y\"|z\n" +
+	"7|x|y"
+
+func TestReadAllTolerant(t *testing.T) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Comma = '|'
+	rw, err := NewRejectWriter(writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(strings.NewReader(sample))
+	r.FieldsPerRecord = 3
+	r.Tolerant = true
+	r.Reject = rw.Reject
+	r.Name = "in.psv"
+	header, recs, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"id", "a", "b"}; !reflect.DeepEqual(header, want) {
+		t.Errorf("header = %v, want %v", header, want)
+	}
+	want := [][]string{{"1", "x", "y"}, {"6", "x|y", "z"}, {"7", "x", "y"}}
+	if !reflect.DeepEqual(recs, want) {
+		t.Errorf("records = %v, want %v", recs, want)
+	}
+	if rw.Total != 4 {
+		t.Errorf("%d rows rejected, want 4", rw.Total)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i, prefix := range []string{
+		"file|line|reason|text",
+		"in.psv|3|wrong number of fields (expected 3, got 2)|\"2|x\"",
+		"in.psv|4|unterminated quoted field|",
+		"in.psv|6|\"bare \"\" in non-quoted-field\"|",
+		"in.psv|7|invalid UTF-8|",
+	} {
+		if i >= len(lines) || !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("rejects line %d does not start with %q:\n%s", i+1, prefix, buf.String())
+		}
+	}
+}
+
+func TestReadAllStrict(t *testing.T) {
+	r := NewReader(strings.NewReader(sample))
+	r.FieldsPerRecord = 3
+	if _, _, err := r.ReadAll(); err == nil {
+		t.Error("malformed input was accepted")
+	}
+	for _, delim := range []string{"|", "||"} {
+		for _, text := range []string{"id|a\n1|x\n2|\xff\n", "id|\xff\n1|x\n"} {
+			r := NewReader(strings.NewReader(strings.ReplaceAll(text, "|", delim)))
+			r.Delimiter = delim
+			if _, _, err := r.ReadAll(); !errors.Is(err, errUTF8) {
+				t.Errorf("delimiter %q, %q: error %v, want invalid UTF-8", delim, text, err)
+			}
+		}
+	}
+}
+
+func TestReadAllEmpty(t *testing.T) {
+	for _, tolerant := range []bool{false, true} {
+		r := NewReader(strings.NewReader(""))
+		r.Tolerant = tolerant
+		header, recs, err := r.ReadAll()
+		if header != nil || recs != nil || err != nil {
+			t.Errorf("tolerant %v: got %v, %v, %v", tolerant, header, recs, err)
+		}
+	}
+}
+
+func TestReadAllBadHeader(t *testing.T) {
+	r := NewReader(strings.NewReader("id|a\n1|x|y\n"))
+	r.FieldsPerRecord = 3
+	r.Tolerant = true
+	if _, _, err := r.ReadAll(); err == nil {
+		t.Error("bad header was accepted")
+	}
+}
+
+func TestReadAllDialect(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		delim  string
+		quote  rune
+		escape rune
+		text   string
+		want   [][]string
+		// records read in tolerant mode, where records may not span
+		// lines, if not want
+		tolerant [][]string
+	}{
+		{"multi-character", "||", '"', 0, "id||a\n1||x|y\n2||\"p||q\"\n", [][]string{{"1", "x|y"}, {"2", "p||q"}}, nil},
+		{"unicode", "¦", '"', 0, "id¦a\r\n1¦é\r\n", [][]string{{"1", "é"}}, nil},
+		{"tab", "\t", '"', 0, "id\ta\n1\tx y\n", [][]string{{"1", "x y"}}, nil},
+		{"quote", "|", '\'', 0, "id|a\n1|'x|''y'''\n2|\"\n", [][]string{{"1", "x|'y'"}, {"2", `"`}}, nil},
+		{"no quote", "|", 0, 0, "id|a\n1|\"x\"\n", [][]string{{"1", `"x"`}}, nil},
+		{"escape", "|", 0, '\\', "id|a\n1|x\\|y\\\\z\\n\n2|a\\\nb\n", [][]string{{"1", "x|y\\z\n"}, {"2", "a\nb"}}, [][]string{{"1", "x|y\\z\n"}}},
+		{"quoted line break", "||", '"', 0, "id||a\n1||\"x\r\ny\"\n\n2||z", [][]string{{"1", "x\ny"}, {"2", "z"}}, [][]string{{"2", "z"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, tolerant := range []bool{false, true} {
+				r := NewReader(strings.NewReader(tc.text))
+				r.Delimiter, r.Quote, r.Escape = tc.delim, tc.quote, tc.escape
+				r.FieldsPerRecord = 2
+				r.Tolerant = tolerant
+				header, recs, err := r.ReadAll()
+				if err != nil {
+					t.Fatalf("tolerant %v: %v", tolerant, err)
+				}
+				if want := []string{"id", "a"}; !reflect.DeepEqual(header, want) {
+					t.Errorf("tolerant %v: header = %q, want %q", tolerant, header, want)
+				}
+				want := tc.want
+				if tolerant && tc.tolerant != nil {
+					want = tc.tolerant
+				}
+				if !reflect.DeepEqual(recs, want) {
+					t.Errorf("tolerant %v: records = %q, want %q", tolerant, recs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadAllLines(t *testing.T) {
+	for _, delim := range []string{"|", "||"} {
+		text := strings.ReplaceAll("id|a\n1|x\n\n2|\"p\nq\"\n3|y\n", "|", delim)
+		r := NewReader(strings.NewReader(text))
+		r.Delimiter = delim
+		if _, _, err := r.ReadAll(); err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{2, 4, 6}; !reflect.DeepEqual(r.Lines(), want) {
+			t.Errorf("delimiter %q: lines = %v, want %v", delim, r.Lines(), want)
+		}
+	}
+}
+
+func TestReadAllDialectErrors(t *testing.T) {
+	for _, text := range []string{
+		"id||a\n1||x\"y\n",
+		"id||a\n1||\"x\"y\n",
+		"id||a\n1||\"x\n",
+		"id||a\n1||x||y\n",
+	} {
+		r := NewReader(strings.NewReader(text))
+		r.Delimiter = "||"
+		if _, _, err := r.ReadAll(); err == nil {
+			t.Errorf("%q was accepted", text)
+		}
+	}
+	for _, d := range []Dialect{
+		{Delimiter: ""},
+		{Delimiter: "\"|", Quote: '"'},
+		{Delimiter: "|", Quote: '"', Escape: '"'},
+		{Delimiter: "\\", Escape: '\\'},
+	} {
+		r := &Reader{Dialect: d, r: strings.NewReader("id|a\n")}
+		if _, _, err := r.ReadAll(); err == nil {
+			t.Errorf("dialect %q %q %q was accepted", r.Delimiter, r.Quote, r.Escape)
+		}
+	}
+}
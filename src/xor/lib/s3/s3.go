@@ -0,0 +1,381 @@
+// Package s3 writes output files to S3 compatible object storage. Files are
+// streamed with multipart uploads, which become visible only when completed,
+// so that they can take part in an idfactor.Transaction.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"xor/lib/idfactor"
+)
+
+// Client sends signed requests to an S3 compatible service. Buckets are
+// addressed in path style, which AWS and self-hosted services both accept.
+type Client struct {
+	// Endpoint is the base URL of the service, e.g.
+	// https://s3.us-east-1.amazonaws.com or http://localhost:9000.
+	Endpoint string
+	// Region is the signing region.
+	Region string
+	// AccessKey, SecretKey and SessionToken are the request credentials.
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// HTTPClient sends requests. If nil, a client that gives up on a
+	// request after DefaultTimeout is used.
+	HTTPClient *http.Client
+}
+
+// DefaultTimeout bounds each request of a Client without an HTTPClient, so
+// that a stalled service fails the run instead of hanging it. It allows for
+// uploading a part over a slow link.
+const DefaultTimeout = 5 * time.Minute
+
+// defaultClient sends the requests of a Client without an HTTPClient
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// ClientFromEnv returns a Client configured from the standard AWS environment
+// variables: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN,
+// AWS_REGION or AWS_DEFAULT_REGION, and AWS_ENDPOINT_URL_S3 or
+// AWS_ENDPOINT_URL for services other than AWS.
+func ClientFromEnv() (*Client, error) {
+	c := &Client{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Region:       firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:     firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return nil, errors.New("s3: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	return c, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Error is an error response from the service.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// do sends a signed request for the given bucket and key and returns the
+// response, or an *Error for any non-2xx status. The request is abandoned if
+// ctx is done first.
+func (c *Client) do(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimRight(c.Endpoint, "/") + "/" + bucket + "/" + key)
+	if err != nil {
+		return nil, fmt.Errorf("s3: bad endpoint: %w", err)
+	}
+	// the uploads subresource is written without a value
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "uploads=", "uploads")
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptyHash
+	if len(body) > 0 {
+		payloadHash = hexHash(body)
+	}
+	c.sign(req, payloadHash, time.Now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		e := &Error{StatusCode: resp.StatusCode}
+		if b, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024)); err == nil {
+			xml.Unmarshal(b, e)
+		}
+		return nil, e
+	}
+	return resp, nil
+}
+
+//------------------------------------------------------------------------------
+// File system
+//------------------------------------------------------------------------------
+
+// DefaultPartSize is the size of multipart upload parts unless otherwise
+// configured.
+const DefaultPartSize = 8 << 20
+
+// minimum part size accepted by S3 for all but the last part
+const minPartSize = 5 << 20
+
+// FS is an idfactor.FS that writes objects under a prefix in a bucket.
+type FS struct {
+	Client *Client
+	Bucket string
+	// Prefix is prepended to every object key, separated by a slash.
+	Prefix string
+	// SSE requests server side encryption: "AES256" or "aws:kms".
+	SSE string
+	// KMSKeyID is the KMS key used when SSE is "aws:kms". If empty, the
+	// service default key is used.
+	KMSKeyID string
+	// PartSize is the size of upload parts. If zero, DefaultPartSize is used.
+	PartSize int
+}
+
+// ParseURL splits a URL of the form s3://bucket/prefix into its bucket and
+// prefix.
+func ParseURL(s string) (bucket, prefix string, err error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf(`s3: bad URL "%s" (expected s3://bucket/prefix)`, s)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// key returns the object key of the named file
+func (fs *FS) key(name string) string {
+	name = strings.TrimLeft(name, "/")
+	if fs.Prefix == "" {
+		return name
+	}
+	return strings.TrimRight(fs.Prefix, "/") + "/" + name
+}
+
+// Exists reports whether an object with the given name exists.
+func (fs *FS) Exists(name string) (bool, error) {
+	resp, err := fs.Client.do(context.Background(), http.MethodHead, fs.Bucket, fs.key(name), nil, nil, nil)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) && e.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Open gets the named object for reading.
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	key := fs.key(name)
+	resp, err := fs.Client.do(context.Background(), http.MethodGet, fs.Bucket, key, nil, nil, nil)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) && e.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf(`s3: error opening "%s": %w`, key, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf(`s3: error opening "%s": %w`, key, err)
+	}
+	return resp.Body, nil
+}
+
+// Create starts a multipart upload for the named object.
+func (fs *FS) Create(name string) (idfactor.StagedFile, error) {
+	return fs.CreateContext(context.Background(), name)
+}
+
+// CreateContext starts a multipart upload for the named object whose part
+// uploads are abandoned once ctx is done. Committing or discarding the upload
+// is not, so that a cancelled run can still clean up.
+func (fs *FS) CreateContext(ctx context.Context, name string) (idfactor.StagedFile, error) {
+	key := fs.key(name)
+	header := http.Header{}
+	if fs.SSE != "" {
+		header.Set("X-Amz-Server-Side-Encryption", fs.SSE)
+		if fs.SSE == "aws:kms" && fs.KMSKeyID != "" {
+			header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", fs.KMSKeyID)
+		}
+	}
+	resp, err := fs.Client.do(ctx, http.MethodPost, fs.Bucket, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`s3: error creating "%s": %w`, key, err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return nil, fmt.Errorf(`s3: error creating "%s": bad response`, key)
+	}
+	size := fs.PartSize
+	if size == 0 {
+		size = DefaultPartSize
+	}
+	if size < minPartSize {
+		size = minPartSize
+	}
+	return &upload{ctx: ctx, fs: fs, key: key, id: result.UploadID, size: size}, nil
+}
+
+// completedPart is a part in a CompleteMultipartUpload request
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// upload is a file staged as a multipart upload
+type upload struct {
+	// ctx bounds the part uploads
+	ctx       context.Context
+	fs        *FS
+	key       string
+	id        string
+	size      int
+	buf       []byte
+	parts     []completedPart
+	closed    bool
+	committed bool
+	err       error
+}
+
+// Write buffers p, uploading a part whenever a full part is buffered.
+func (u *upload) Write(p []byte) (int, error) {
+	if u.closed {
+		return 0, os.ErrClosed
+	}
+	if u.err != nil {
+		return 0, u.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := u.size - len(u.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		u.buf = append(u.buf, p[:m]...)
+		p = p[m:]
+		if len(u.buf) == u.size {
+			if u.err = u.flush(); u.err != nil {
+				return n - len(p), u.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush uploads the buffered data as the next part
+func (u *upload) flush() error {
+	number := len(u.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.id}}
+	resp, err := u.fs.Client.do(u.ctx, http.MethodPut, u.fs.Bucket, u.key, query, nil, u.buf)
+	if err != nil {
+		return fmt.Errorf(`s3: error uploading part %d of "%s": %w`, number, u.key, err)
+	}
+	resp.Body.Close()
+	u.parts = append(u.parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	u.buf = u.buf[:0]
+	return nil
+}
+
+// Close uploads any buffered data as the final part. It may be called more
+// than once.
+func (u *upload) Close() error {
+	if u.closed {
+		return u.err
+	}
+	u.closed = true
+	if u.err == nil && (len(u.buf) > 0 || len(u.parts) == 0) {
+		u.err = u.flush()
+	}
+	u.buf = nil
+	return u.err
+}
+
+// Commit completes the multipart upload, making the object visible.
+func (u *upload) Commit() error {
+	if !u.closed {
+		return fmt.Errorf(`s3: error committing "%s": file not closed`, u.key)
+	}
+	if u.err != nil {
+		return u.err
+	}
+	if u.committed {
+		return nil
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: u.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := u.fs.Client.do(context.Background(), http.MethodPost, u.fs.Bucket, u.key, url.Values{"uploadId": {u.id}}, nil, body)
+	if err != nil {
+		return fmt.Errorf(`s3: error committing "%s": %w`, u.key, err)
+	}
+	defer resp.Body.Close()
+	// the service may report an error with a 200 status once the upload
+	// has started completing
+	b, _ := io.ReadAll(resp.Body)
+	var e Error
+	if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+		e.StatusCode = resp.StatusCode
+		return fmt.Errorf(`s3: error committing "%s": %w`, u.key, &e)
+	}
+	u.committed = true
+	return nil
+}
+
+// Discard aborts the upload, or deletes the object if it was committed. An
+// object replaced by the upload cannot be restored unless the bucket is
+// versioned.
+func (u *upload) Discard() error {
+	u.closed = true
+	u.buf = nil
+	var resp *http.Response
+	var err error
+	if u.committed {
+		resp, err = u.fs.Client.do(context.Background(), http.MethodDelete, u.fs.Bucket, u.key, nil, nil, nil)
+		u.committed = false
+	} else {
+		resp, err = u.fs.Client.do(context.Background(), http.MethodDelete, u.fs.Bucket, u.key, url.Values{"uploadId": {u.id}}, nil, nil)
+	}
+	if err != nil {
+		return fmt.Errorf(`s3: error discarding "%s": %w`, u.key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Release has nothing to free.
+func (u *upload) Release() error {
+	return nil
+}
@@ -1,25 +1,87 @@
+// Package shuffle generates unpredictable permutations. By default it uses a
+// ChaCha8 keystream keyed from crypto/rand, so that shuffled output reveals
+// nothing about input order.
 package shuffle
 
 import (
-	"crypto/rand"
 	"log"
-	"math/big"
+
+	"xor/lib/csprng"
 )
 
+// Shuffler generates permutations using randomness drawn from a Source. A
+// Shuffler is safe for concurrent use only if its Source is.
+type Shuffler struct {
+	src csprng.Source
+}
+
+// New returns a Shuffler that draws randomness from src.
+func New(src csprng.Source) *Shuffler {
+	return &Shuffler{src: src}
+}
+
 // Shuffle returns an unpredictable permuation of the integers [0,n)
 func Shuffle(n int) []int {
-	if n < 1 {
-		log.Fatal("shuffle: n must be positive in call to Shuffle")
+	return New(csprng.NewStream()).Shuffle(n)
+}
+
+// Shuffle64 returns an unpredictable permutation of the integers [0,n) for
+// inputs too large to index with an int.
+func Shuffle64(n int64) []int64 {
+	return New(csprng.NewStream()).Shuffle64(n)
+}
+
+// Ints shuffles the given slice in place into an unpredictable order.
+func Ints(a []int) {
+	New(csprng.NewStream()).Ints(a)
+}
+
+// InPlace shuffles a collection of n elements in place into an unpredictable
+// order, calling swap to exchange the elements with indexes i and j.
+func InPlace(n int64, swap func(i, j int64)) {
+	New(csprng.NewStream()).InPlace(n, swap)
+}
+
+// Shuffle returns a random permutation of the integers [0,n)
+func (s *Shuffler) Shuffle(n int) []int {
+	if n < 0 {
+		log.Fatal("shuffle: n must not be negative in call to Shuffle")
 	}
 	a := make([]int, n)
 	for i := 0; i != n; i++ {
-		J, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
-		if err != nil {
-			log.Fatalf("shuffle: failed to generate random number in call to Shuffle: %s", err)
-		}
-		j := J.Int64()
+		j := csprng.Bounded(s.src, uint64(i+1))
 		a[i], a[j] = a[j], i
 	}
+	return a
+}
 
+// Shuffle64 returns a random permutation of the integers [0,n) for inputs too
+// large to index with an int.
+func (s *Shuffler) Shuffle64(n int64) []int64 {
+	if n < 0 {
+		log.Fatal("shuffle: n must not be negative in call to Shuffle64")
+	}
+	a := make([]int64, n)
+	for i := int64(0); i != n; i++ {
+		j := csprng.Bounded(s.src, uint64(i+1))
+		a[i], a[j] = a[j], i
+	}
 	return a
 }
+
+// Ints shuffles the given slice in place into a random order.
+func (s *Shuffler) Ints(a []int) {
+	s.InPlace(int64(len(a)), func(i, j int64) { a[i], a[j] = a[j], a[i] })
+}
+
+// InPlace shuffles a collection of n elements in place into a random order,
+// calling swap to exchange the elements with indexes i and j.
+func (s *Shuffler) InPlace(n int64, swap func(i, j int64)) {
+	if n < 0 {
+		log.Fatal("shuffle: n must not be negative in call to InPlace")
+	}
+	for i := n - 1; i > 0; i-- {
+		j := int64(csprng.Bounded(s.src, uint64(i+1)))
+		swap(i, j)
+	}
+}
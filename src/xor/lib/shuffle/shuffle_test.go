@@ -0,0 +1,124 @@
+package shuffle
+
+import (
+	"fmt"
+	"testing"
+
+	"xor/lib/csprng"
+)
+
+func TestShuffleIsPermutation(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 10, 1000} {
+		seen := make([]bool, n)
+		for _, v := range Shuffle(n) {
+			if v < 0 || v >= n || seen[v] {
+				t.Fatalf("Shuffle(%d) is not a permutation", n)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestShuffle64IsPermutation(t *testing.T) {
+	for _, n := range []int64{0, 1, 2, 10, 1000} {
+		seen := make([]bool, n)
+		for _, v := range Shuffle64(n) {
+			if v < 0 || v >= n || seen[v] {
+				t.Fatalf("Shuffle64(%d) is not a permutation", n)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestInts(t *testing.T) {
+	a := make([]int, 1000)
+	for i := range a {
+		a[i] = i
+	}
+	Ints(a)
+	seen := make([]bool, len(a))
+	fixed := 0
+	for i, v := range a {
+		if seen[v] {
+			t.Fatalf("Ints lost element %d", v)
+		}
+		seen[v] = true
+		if i == v {
+			fixed++
+		}
+	}
+	// a random permutation has one fixed point on average
+	if fixed > 10 {
+		t.Errorf("Ints left %d of %d elements in place", fixed, len(a))
+	}
+	Ints(nil)
+}
+
+// checkUniform checks that each of the 6 permutations of 3 items is equally
+// likely to be produced by perm.
+func checkUniform(t *testing.T, perm func() [3]int) {
+	const samples = 60000
+	counts := make(map[[3]int]int)
+	for i := 0; i < samples; i++ {
+		counts[perm()]++
+	}
+	if len(counts) != 6 {
+		t.Fatalf("saw %d distinct permutations of 3 items", len(counts))
+	}
+	for p, c := range counts {
+		if c < samples/6*9/10 || c > samples/6*11/10 {
+			t.Errorf("permutation %v seen %d times in %d", p, c, samples)
+		}
+	}
+}
+
+func TestShuffleUniform(t *testing.T) {
+	checkUniform(t, func() (p [3]int) {
+		copy(p[:], Shuffle(3))
+		return p
+	})
+}
+
+func TestInPlaceUniform(t *testing.T) {
+	checkUniform(t, func() [3]int {
+		p := [3]int{0, 1, 2}
+		InPlace(3, func(i, j int64) { p[i], p[j] = p[j], p[i] })
+		return p
+	})
+}
+
+var benchSizes = []int{1e3, 1e4, 1e5, 1e6, 1e7}
+
+func BenchmarkShuffle(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Shuffle(n)
+			}
+		})
+	}
+}
+
+func BenchmarkInts(b *testing.B) {
+	for _, n := range benchSizes {
+		a := make([]int, n)
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Ints(a)
+			}
+		})
+	}
+}
+
+func TestSeededShufflerDeterministic(t *testing.T) {
+	a := New(csprng.NewInsecureStream(7, "test")).Shuffle(100)
+	b := New(csprng.NewInsecureStream(7, "test")).Shuffle(100)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatal("shufflers with equal seeds produced different permutations")
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package synth
+
+var firstNames = []string{
+	"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda",
+	"David", "Elizabeth", "William", "Barbara", "Richard", "Susan", "Joseph", "Jessica",
+	"Thomas", "Sarah", "Charles", "Karen", "Christopher", "Lisa", "Daniel", "Nancy",
+	"Matthew", "Betty", "Anthony", "Margaret", "Mark", "Sandra", "Donald", "Ashley",
+	"Steven", "Kimberly", "Paul", "Emily", "Andrew", "Donna", "Joshua", "Michelle",
+	"José", "María", "Luis", "Sofía", "Wei", "Mei", "Aarav", "Priya",
+	"Chloé", "Zoë", "Renée", "André", "Jürgen", "Björn", "Siobhán", "Nuño",
+}
+
+var lastNames = []string{
+	"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis",
+	"Rodriguez", "Martinez", "Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas",
+	"Taylor", "Moore", "Jackson", "Martin", "Lee", "Perez", "Thompson", "White",
+	"Harris", "Sanchez", "Clark", "Ramirez", "Lewis", "Robinson", "Walker", "Young",
+	"Allen", "King", "Wright", "Scott", "Torres", "Nguyen", "Hill", "Flores",
+	"O'Brien", "Núñez", "Müller", "Østergaard", "Patel", "Kim", "Chen", "Nakamura",
+}
+
+var suffixes = []string{"Jr", "Sr", "II", "III", "IV"}
+
+var streets = []string{
+	"Main", "Oak", "Pine", "Maple", "Cedar", "Elm", "Washington", "Lake",
+	"Hill", "Park", "Walnut", "Sunset", "Lincoln", "Jackson", "Highland", "Church",
+	"Spring", "Ridge", "Meadow", "River", "Forest", "Willow", "Mill", "Center",
+}
+
+var streetTypes = []string{"St", "Ave", "Rd", "Blvd", "Ln", "Dr", "Ct", "Way", "Pl"}
+
+var domains = []string{"example.com", "example.net", "example.org"}
+
+// locations pairs cities with their state, a zip code prefix and a telephone
+// area code so that generated addresses and phones are plausible together.
+var locations = []struct {
+	city, state, zip, area string
+}{
+	{"Atlanta", "GA", "303", "404"},
+	{"Austin", "TX", "787", "512"},
+	{"Boston", "MA", "021", "617"},
+	{"Chicago", "IL", "606", "312"},
+	{"Columbus", "OH", "432", "614"},
+	{"Denver", "CO", "802", "303"},
+	{"Detroit", "MI", "482", "313"},
+	{"Houston", "TX", "770", "713"},
+	{"Kansas City", "MO", "641", "816"},
+	{"Los Angeles", "CA", "900", "213"},
+	{"Miami", "FL", "331", "305"},
+	{"Minneapolis", "MN", "554", "612"},
+	{"Nashville", "TN", "372", "615"},
+	{"New York", "NY", "100", "212"},
+	{"Philadelphia", "PA", "191", "215"},
+	{"Phoenix", "AZ", "850", "602"},
+	{"Portland", "OR", "972", "503"},
+	{"Salt Lake City", "UT", "841", "801"},
+	{"San Diego", "CA", "921", "619"},
+	{"Seattle", "WA", "981", "206"},
+}
+
+// asciiFold maps the accented lower case letters used above to ascii.
+var asciiFold = map[rune]rune{
+	'á': 'a', 'é': 'e', 'í': 'i', 'ó': 'o', 'ú': 'u', 'ñ': 'n', 'ü': 'u',
+	'ö': 'o', 'ë': 'e', 'ø': 'o',
+}
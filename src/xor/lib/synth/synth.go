@@ -0,0 +1,246 @@
+// Package synth generates synthetic identity records for testing and
+// benchmarking. The generated values are plausible but fictitious: SSNs are
+// drawn from area numbers that are never issued, phone numbers from the range
+// reserved for fictional use, and email addresses from reserved domains.
+package synth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+)
+
+// Config controls the records produced by a Generator.
+type Config struct {
+	// Compromised selects the compromised entity record format, which adds a
+	// breach identifier after the record identifier.
+	Compromised bool
+	// Breaches is the number of distinct breach identifiers to draw from in
+	// compromised format. Values less than one are treated as one.
+	Breaches int
+	// DuplicateRate is the probability that an identity element (name and
+	// dob, ssn, address, phone, email or username) is copied from a recently
+	// generated record instead of being freshly generated.
+	DuplicateRate float64
+	// EmptyRate is the probability that an individual field other than the
+	// record and breach identifiers is left empty.
+	EmptyRate float64
+	// Seed seeds the generator. Generators with equal configurations produce
+	// identical sequences of records.
+	Seed int64
+}
+
+// number of recent records retained as candidates for duplication
+const recentSize = 1024
+
+// Generator produces a sequence of synthetic identity records.
+type Generator struct {
+	cfg    Config
+	rng    *rand.Rand
+	n      int
+	recent [][]string
+}
+
+// New returns a Generator for the given configuration.
+func New(cfg Config) *Generator {
+	if cfg.Breaches < 1 {
+		cfg.Breaches = 1
+	}
+	return &Generator{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}
+}
+
+// AtRiskHeader is the column header of at-risk entity input files.
+var AtRiskHeader = atrisk.Header
+
+// CompromisedHeader is the column header of compromised entity input files.
+var CompromisedHeader = compromised.Header
+
+// Header returns the column header for the generated records.
+func (g *Generator) Header() []string {
+	if g.cfg.Compromised {
+		return CompromisedHeader
+	}
+	return AtRiskHeader
+}
+
+// identity is a record in a format neutral form. Each element group is kept
+// together so that duplication copies whole elements.
+type identity struct {
+	first, last, middle, suffix, dob string
+	ssn                              string
+	line1, line2, city, state, zip   string
+	phone                            string
+	email                            string
+	username                         string
+}
+
+// Next returns the next synthetic record.
+func (g *Generator) Next() []string {
+	g.n++
+	id := g.identity()
+
+	var rec []string
+	if g.cfg.Compromised {
+		rec = make([]string, compromised.RecordLength)
+		rec[compromised.RecordIDField] = fmt.Sprintf("RECORD-%09d", g.n)
+		rec[compromised.BreachIDField] = fmt.Sprintf("BREACH-%03d", 1+g.rng.Intn(g.cfg.Breaches))
+		copy(rec[compromised.FirstNameField:], id.fields())
+	} else {
+		rec = make([]string, atrisk.RecordLength)
+		rec[atrisk.RecordIDField] = fmt.Sprintf("RECORD-%09d", g.n)
+		copy(rec[atrisk.FirstNameField:], id.fields())
+	}
+
+	// remember a window of recent records for duplication
+	if len(g.recent) < recentSize {
+		g.recent = append(g.recent, rec)
+	} else {
+		g.recent[g.rng.Intn(recentSize)] = rec
+	}
+	return rec
+}
+
+// fields returns the identity fields in input file order.
+func (id *identity) fields() []string {
+	return []string{
+		id.first, id.last, id.middle, id.suffix, id.dob,
+		id.ssn,
+		id.line1, id.line2, id.city, id.state, id.zip,
+		id.phone,
+		id.email,
+		id.username,
+	}
+}
+
+// identity generates a new identity, copying elements from recent records
+// with probability DuplicateRate.
+func (g *Generator) identity() *identity {
+	id := &identity{}
+
+	// name and dob
+	id.first = pick(g.rng, firstNames)
+	id.last = pick(g.rng, lastNames)
+	id.middle = string(rune('A' + g.rng.Intn(26)))
+	if g.rng.Intn(10) == 0 {
+		id.suffix = pick(g.rng, suffixes)
+	}
+	id.dob = fmt.Sprintf("%04d-%02d-%02d", 1930+g.rng.Intn(76), 1+g.rng.Intn(12), 1+g.rng.Intn(28))
+	if src := g.duplicate(); src != nil {
+		id.first, id.last, id.middle, id.suffix, id.dob = src[0], src[1], src[2], src[3], src[4]
+	}
+
+	// ssn in the never issued 9xx area range
+	id.ssn = fmt.Sprintf("%03d-%02d-%04d", 900+g.rng.Intn(100), 1+g.rng.Intn(99), 1+g.rng.Intn(9999))
+	if src := g.duplicate(); src != nil {
+		id.ssn = src[5]
+	}
+
+	// address
+	loc := locations[g.rng.Intn(len(locations))]
+	id.line1 = fmt.Sprintf("%d %s %s", 1+g.rng.Intn(9999), pick(g.rng, streets), pick(g.rng, streetTypes))
+	if g.rng.Intn(5) == 0 {
+		id.line2 = fmt.Sprintf("Apt %d%c", 1+g.rng.Intn(40), 'A'+g.rng.Intn(6))
+	}
+	id.city, id.state = loc.city, loc.state
+	id.zip = fmt.Sprintf("%s%02d", loc.zip, g.rng.Intn(100))
+	if src := g.duplicate(); src != nil {
+		id.line1, id.line2, id.city, id.state, id.zip = src[6], src[7], src[8], src[9], src[10]
+	}
+
+	// phone in the 555-0100 to 555-0199 range reserved for fictional use
+	id.phone = fmt.Sprintf("%s-555-01%02d", loc.area, g.rng.Intn(100))
+	if src := g.duplicate(); src != nil {
+		id.phone = src[11]
+	}
+
+	// email and username
+	first, last := handle(id.first), handle(id.last)
+	id.email = fmt.Sprintf("%s.%s%d@%s", first, last, g.rng.Intn(1000), pick(g.rng, domains))
+	if src := g.duplicate(); src != nil {
+		id.email = src[12]
+	}
+	id.username = fmt.Sprintf("%.1s%s%d", first, last, g.rng.Intn(10000))
+	if src := g.duplicate(); src != nil {
+		id.username = src[13]
+	}
+
+	// blank out fields
+	if g.cfg.EmptyRate > 0 {
+		for _, f := range []*string{
+			&id.first, &id.last, &id.middle, &id.suffix, &id.dob,
+			&id.ssn,
+			&id.line1, &id.line2, &id.city, &id.state, &id.zip,
+			&id.phone,
+			&id.email,
+			&id.username,
+		} {
+			if g.rng.Float64() < g.cfg.EmptyRate {
+				*f = ""
+			}
+		}
+	}
+	return id
+}
+
+// duplicate returns the identity fields of a recent record with probability
+// DuplicateRate and nil otherwise.
+func (g *Generator) duplicate() []string {
+	if len(g.recent) == 0 || g.cfg.DuplicateRate <= 0 || g.rng.Float64() >= g.cfg.DuplicateRate {
+		return nil
+	}
+	rec := g.recent[g.rng.Intn(len(g.recent))]
+	if g.cfg.Compromised {
+		return rec[compromised.FirstNameField:]
+	}
+	return rec[atrisk.FirstNameField:]
+}
+
+// Records returns n synthetic records.
+func (g *Generator) Records(n int) [][]string {
+	recs := make([][]string, n)
+	for i := range recs {
+		recs[i] = g.Next()
+	}
+	return recs
+}
+
+// Write writes a header and n synthetic records to w using the given field
+// delimiter.
+func Write(w io.Writer, cfg Config, n int, comma rune) error {
+	g := New(cfg)
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	if err := writer.Write(g.Header()); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := writer.Write(g.Next()); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// handle folds a name to the lower case ascii letters used in email addresses
+// and usernames.
+func handle(name string) string {
+	return strings.Map(func(r rune) rune {
+		if f, ok := asciiFold[r]; ok {
+			r = f
+		}
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(name))
+}
+
+func pick(rng *rand.Rand, xs []string) string {
+	return xs[rng.Intn(len(xs))]
+}
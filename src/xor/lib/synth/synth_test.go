@@ -0,0 +1,61 @@
+package synth
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"xor/lib/idfactor/atrisk"
+	"xor/lib/idfactor/compromised"
+)
+
+func TestDeterministic(t *testing.T) {
+	cfg := Config{Compromised: true, Breaches: 3, DuplicateRate: 0.3, EmptyRate: 0.1, Seed: 42}
+	a := New(cfg).Records(500)
+	b := New(cfg).Records(500)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("generators with equal configurations produced different records")
+	}
+}
+
+func TestFormats(t *testing.T) {
+	ssn := regexp.MustCompile(`^9\d\d-(0[1-9]|[1-9]\d)-(\d{3}[1-9]|\d{2}[1-9]\d|\d[1-9]\d\d|[1-9]\d{3})$`)
+	phone := regexp.MustCompile(`^\d{3}-555-01\d\d$`)
+	email := regexp.MustCompile(`^[a-z]*\.[a-z]*\d+@example\.(com|net|org)$`)
+	ids := make(map[string]bool)
+	for _, rec := range New(Config{Seed: 1}).Records(2000) {
+		if len(rec) != atrisk.RecordLength {
+			t.Fatalf("record has %d fields, want %d", len(rec), atrisk.RecordLength)
+		}
+		if ids[rec[atrisk.RecordIDField]] {
+			t.Errorf("record id %s repeated", rec[atrisk.RecordIDField])
+		}
+		ids[rec[atrisk.RecordIDField]] = true
+		if !ssn.MatchString(rec[atrisk.SsnField]) {
+			t.Errorf("ssn %q outside the unissued range", rec[atrisk.SsnField])
+		}
+		if !phone.MatchString(rec[atrisk.PhoneField]) {
+			t.Errorf("phone %q outside the fictional range", rec[atrisk.PhoneField])
+		}
+		if !email.MatchString(rec[atrisk.EmailField]) {
+			t.Errorf("email %q not in a reserved domain", rec[atrisk.EmailField])
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	const n = 5000
+	recs := New(Config{Compromised: true, EmptyRate: 0.25, Seed: 7}).Records(n)
+	empty := 0
+	for _, rec := range recs {
+		if rec[compromised.RecordIDField] == "" || rec[compromised.BreachIDField] == "" {
+			t.Fatal("record or breach id left empty")
+		}
+		if rec[compromised.SsnField] == "" {
+			empty++
+		}
+	}
+	if rate := float64(empty) / n; rate < 0.2 || rate > 0.3 {
+		t.Errorf("empty ssn rate %.3f, want about 0.25", rate)
+	}
+}
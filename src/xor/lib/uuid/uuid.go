@@ -1,26 +1,70 @@
 package uuid
 
 import (
-	"crypto/rand"
-	"fmt"
-	"log"
+	"xor/lib/csprng"
 )
 
-// read random bytes from a csprng or panic
-func safeRandom(dst []byte) {
-	if _, err := rand.Read(dst); err != nil {
-		log.Fatalf("uuid: failed reading random bytes in call to New: %s", err)
-	}
-}
+const hexDigits = "0123456789abcdef"
 
 // New returns a new Version 4 (random) UUID in canonical string form
 func New() string {
-	u := make([]byte, 16)
-	safeRandom(u)
+	var u [16]byte
+	csprng.Read(u[:])
+	return format(&u)
+}
+
+// NewFrom returns a new Version 4 UUID in canonical string form using random
+// bytes read from src.
+func NewFrom(src csprng.Source) string {
+	var u [16]byte
+	src.Read(u[:])
+	return format(&u)
+}
+
+// NewV7From returns a new Version 7 (time ordered) UUID in canonical string
+// form for the given Unix time in milliseconds, using random bytes read from
+// src for the remaining bits.
+func NewV7From(src csprng.Source, ms uint64) string {
+	var u [16]byte
+	src.Read(u[6:])
+	// 48-bit big endian timestamp
+	for i := 5; i >= 0; i-- {
+		u[i] = byte(ms)
+		ms >>= 8
+	}
+	return formatVersion(&u, 7)
+}
+
+// format sets the version 4 and variant bits of u and returns its canonical
+// string form
+func format(u *[16]byte) string {
+	return formatVersion(u, 4)
+}
+
+// formatVersion sets the version and variant bits of u and returns its
+// canonical string form
+func formatVersion(u *[16]byte, version byte) string {
 	// set version bits
-	u[6] = (u[6] & 0x0f) | 0x40
+	u[6] = (u[6] & 0x0f) | version<<4
 	// set variant bits
 	u[8] = (u[8] & 0xbf) | 0x80
 	// return string representation
-	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+	var buf [36]byte
+	encode(&buf, u)
+	return string(buf[:])
+}
+
+// encode writes the canonical hex form of u into buf
+func encode(buf *[36]byte, u *[16]byte) {
+	j := 0
+	for i, b := range u {
+		switch i {
+		case 4, 6, 8, 10:
+			buf[j] = '-'
+			j++
+		}
+		buf[j] = hexDigits[b>>4]
+		buf[j+1] = hexDigits[b&0x0f]
+		j += 2
+	}
 }
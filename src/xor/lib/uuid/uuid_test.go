@@ -0,0 +1,54 @@
+package uuid
+
+import (
+	"regexp"
+	"testing"
+
+	"xor/lib/csprng"
+)
+
+func TestNew(t *testing.T) {
+	canonical := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		u := New()
+		if !canonical.MatchString(u) {
+			t.Fatalf("%q is not a canonical version 4 UUID", u)
+		}
+		if seen[u] {
+			t.Fatalf("%q repeated", u)
+		}
+		seen[u] = true
+	}
+}
+
+func TestNewV7From(t *testing.T) {
+	canonical := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	src := csprng.New()
+	u := NewV7From(src, 0x0123456789ab)
+	if !canonical.MatchString(u) {
+		t.Fatalf("%q is not a canonical version 7 UUID", u)
+	}
+	if u[:13] != "01234567-89ab" {
+		t.Errorf("%q does not start with the timestamp", u)
+	}
+	if a, b := NewV7From(src, 1000), NewV7From(src, 1001); a >= b {
+		t.Errorf("%q sorts after later %q", a, b)
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New()
+	}
+}
+
+func BenchmarkNewParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			New()
+		}
+	})
+}